@@ -10,6 +10,7 @@ import (
 	pf "github.com/rafayopen/perftest/pkg/flag"
 	pt "github.com/rafayopen/perftest/pkg/pt"
 	srv "github.com/rafayopen/perftest/pkg/srv"
+	store "github.com/rafayopen/perftest/pkg/store"
 
 	"bytes"
 	"encoding/json"
@@ -35,8 +36,61 @@ const usage = `Usage: %s [flags] URL ...
 URLs to test -- there may be multiple of them, all will be tested in parallel.
 Continue to issue requests every $delay seconds; if delay==0, make requests until interrupted.
 Can stop after some number of cycles (-n), or when enough failures occur, or signaled to stop.
-
-Can send an alert if desired if total response time is over a threshold.
+Failures include requests that get no response or an HTTP error status, responses
+failing the per-target assertions in the -C config file, and with -s, HTTPS servers
+whose certificate chain fails verification.
+
+A URL like dns://name?type=A&server=8.8.8.8&expect=1.2.3.4 times a DNS query instead
+of an HTTP request: no answer, an error rcode or a missing expected answer is a
+failure.
+A URL like tcp://host:port or tls://host:port only connects (and does the TLS
+handshake), then closes: a connection error is a failure.
+A URL like grpc://host:port/service (or grpcs:// over TLS) calls the gRPC health
+check for the service: an error or a status other than SERVING is a failure.
+A URL like ws://host/path (or wss://) times the WebSocket upgrade and, with the
+per-target WsMessage in the -C config file, message round trips.
+
+A target with Steps in the -C config file is a transaction of several requests,
+passing values from one response to the next, timed as a whole and by step.
+
+Can record response headers (-X), like the cache status from a CDN, and summarize
+results by cache status (-G), since a cache HIT and MISS differ so much.
+
+Can report the download throughput of responses (-D), and time uploads of a
+generated payload (-U bytes) to measure bandwidth as well as latency.
+
+With -I on Linux, records the kernel's TCP_INFO for each connection: smoothed RTT,
+retransmits and congestion window, to tell network loss from a slow server.
+
+Durations from a W3C Server-Timing response header, like db;dur=12, are added
+to the results and summaries, to tell server time from network time.
+
+With -L, load tests instead of monitoring: requests are made at a rate, which may
+ramp up or down in stages, whatever the response time, with at most -N in flight.
+Each stage reports throughput, error rate and latency percentiles, measured from
+when each request was due so a slow server does not hide its own latency.
+
+With -u, load tests with that many virtual users per target instead, like ab -c:
+each makes requests one after another (-n each, or until interrupted) on a kept
+connection, with -t think time between them.
+
+A target with a Cron in the -C config file is tested at the minutes of the cron
+expression, like "*/5 9-17 * * 1-5", instead of every -d seconds.  Maintenance
+windows in the config file, or added with the web server's /maintenance API,
+pause the tests of matching targets or silence their alerts.
+
+With -B, stores each result in a directory, with rollups per minute and hour, to
+keep the history across restarts.  "perftest query -B dir" prints it (see
+"perftest query -h"), and so does the web server's /history.
+
+Reports an Apdex score for each URL, using threshold -T (or per-target ApdexMsec
+from the -C config file), both since start and over the last -w seconds.
+
+Can send an alert if desired on a failure, if total response time is over a
+threshold, or if any time component is an anomaly: more than -S standard
+deviations slower than the baseline learned from previous samples of the same
+URL, or if the response body changes from the previous sample (with -H), or if
+the server certificate expires within -e days.
 Supported alerting mechanisms:
   - Twilio (requires account ID and API key in shell environment)
 
@@ -50,15 +104,41 @@ var (
 	// Location of perftest instance to be published to Cloudwatch
 	myLocation string
 
-	delayFlag     = flag.Int("d", 10, "delay in seconds between test requests")
+	delayFlag     = flag.Float64("d", 10, "delay in seconds between test requests, like 10 or 0.5")
+	jitterMsec    = flag.Int64("J", 0, "delay each test request by a random jitter up to this many milliseconds")
+	staggerFlag   = flag.Bool("o", false, "stagger targets: offset the start of each evenly across the delay")
 	maxFails      = flag.Int("f", 10, "maximum number of failures before process quits")
 	numTests      = flag.Int("n", 0, "number of tests to each endpoint (default 0 runs until interrupted)")
 	jsonFlag      = flag.Bool("j", false, "write detailed metrics in JSON (default is text TSV format)")
 	alertMsec     = flag.Int64("A", 0, "alert threshold in milliseconds")
 	alertInterval = flag.Int64("M", 300, "minimum time interval between generated alerts (seconds)")
+	apdexMsec     = flag.Int64("T", 500, "Apdex threshold T in milliseconds (satisfied <= T, tolerating <= 4T)")
+	windowFlag    = flag.Int("w", 300, "rolling window in seconds for recent Apdex scores")
+	configFlag    = flag.String("C", "", "JSON config file listing targets with per-target settings")
+	sigmaFlag     = flag.Float64("S", 0, "anomaly threshold in standard deviations over baseline (0 disables)")
+	hashFlag      = flag.Bool("H", false, "hash response bodies (SHA-256) to detect content changes")
+	strictTLS     = flag.Bool("s", false, "strict TLS: verify server certificate chains, errors are failures")
+	certWarnDays  = flag.Int("e", 0, "alert when a server certificate expires within this many days (0 disables)")
+	allAddrs      = flag.Bool("a", false, "test every address each host resolves to, and report each separately")
+	familyFlag    = flag.String("F", "", "address family: 4 (IPv4 only), 6 (IPv6 only), or both (compare them)")
+	downloadFlag  = flag.Bool("D", false, "report download throughput (bytes per second) of each response")
+	uploadBytes   = flag.Int64("U", 0, "POST a generated payload of this many bytes, reporting upload throughput")
+	encodingFlag  = flag.String("E", "", "Accept-Encoding to send: gzip, br, zstd, deflate, identity or a list (default gzip, decoded)")
+	headersFlag   = flag.String("X", "", "response headers to record, comma separated (\"cdn\" for common CDN cache headers)")
+	groupCache    = flag.Bool("G", false, "summarize results by CDN cache status (HIT, MISS, ...) as well")
+	loadFlag      = flag.String("L", "", "load test at rate@duration stages, like 50-500@5m,500@2m (requests per second)")
+	loadWorkers   = flag.Int("N", 100, "most requests in flight per target in a load test")
+	usersFlag     = flag.Int("u", 0, "load test with this many virtual users per target, each making requests one after another")
+	thinkMsec     = flag.Int64("t", 0, "think time in milliseconds of a virtual user between requests")
+	tcpInfoFlag   = flag.Bool("I", false, "record kernel TCP_INFO (RTT, retransmits, cwnd) of each connection (Linux)")
+	protoFlag     = flag.String("P", "", "HTTP protocol: h1, h2, h2c (HTTP/2 without TLS) or h3 (HTTP/3 over QUIC); default negotiates h2 or HTTP/1.1")
+	resolverFlag  = flag.String("r", "", "DNS resolver: addr, udp://addr, tcp://addr, tls://addr or https://url (default system)")
 	cwFlag        = flag.Bool("c", false, "Publish metrics to CloudWatch (requires AWS credentials in env)")
 	webhook       = flag.String("W", "", "Webhook target URL to receive JSON log details via POST")
 	portFlag      = flag.Int("p", 0, "run web server on this port (if non-zero) to report stats")
+	storeFlag     = flag.String("B", "", "store results in this directory, keeping their history (see perftest query)")
+	keepFlag      = flag.String("k", "7d", "keep stored results this long, like 7d or 36h (rollups are kept longer)")
+	storeMBytes   = flag.Int64("Z", 0, "most megabytes of stored results, removing the oldest first (default no limit)")
 	qf            = flag.Bool("q", false, "be quiet, not verbose")
 	vf1           = flag.Bool("v", false, "be verbose")
 	vf2           = flag.Bool("V", false, "be more verbose")
@@ -69,11 +149,19 @@ var (
 	verbose = 1
 
 	alertThresh time.Duration      // alert threshold value (from environment)
+	resolves    pf.StringArrayFlag // host:port:addr entries from -R
 	twilioSms   pf.StringArrayFlag // array of Twilio SMS numbers to alert
 	twilioKey   string             // holds Twilio accountSid:authToken
 	smsSender   string             // SMS sender number registered -- must be with Twilio
+
+	maintenance = pt.NewMaintenance() // windows pausing tests or silencing alerts
+	resultStore *store.Store          // history of results, with -B
 )
 
+func init() {
+	flag.Var(&resolves, "R", "connect to addr for host:port, given as host:port:addr (may be repeated)")
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, usage, os.Args[0])
 	flag.PrintDefaults()
@@ -125,6 +213,10 @@ func publishJSON(url string, pt *pt.PingTimes) {
 
 // Read command line arguments, take action, and report results to stdout.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(runQuery(os.Args[2:]))
+	}
+
 	flag.Usage = printUsage
 	flag.Parse()
 
@@ -193,7 +285,102 @@ func main() {
 		}
 	}
 
-	if len(urls) == 0 {
+	var targets []pt.Target
+	for _, url := range urls {
+		targets = append(targets, pt.Target{Url: url})
+	}
+
+	configFile := os.Getenv("PERFTEST_CONFIG")
+	if len(*configFlag) > 0 {
+		if len(configFile) > 0 {
+			log.Println("NOTE: overwriting config file from env,", configFile, "via command line")
+		}
+		configFile = *configFlag
+	}
+	if len(configFile) > 0 {
+		cfg, err := pt.ReadConfig(configFile)
+		if err != nil {
+			log.Println("Error: config file:", err)
+			os.Exit(1)
+		}
+		for _, t := range cfg.Targets {
+			targets = append(targets, t)
+			urls = append(urls, t.Url)
+		}
+		for i, w := range cfg.Maintenance {
+			if _, err := maintenance.Add(w); err != nil {
+				log.Println("Error: config file: maintenance window", i+1, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	for i := range targets {
+		if targets[i].ApdexMsec == 0 {
+			targets[i].ApdexMsec = *apdexMsec
+		}
+		if targets[i].AnomalySigmas == 0 {
+			targets[i].AnomalySigmas = *sigmaFlag
+		}
+		if *hashFlag {
+			targets[i].HashBody = true
+		}
+		if *strictTLS {
+			targets[i].VerifyTLS = true
+		}
+		if targets[i].CertWarnDays == 0 {
+			targets[i].CertWarnDays = *certWarnDays
+		}
+		if *allAddrs {
+			targets[i].AllAddrs = true
+		}
+		if len(targets[i].Family) == 0 {
+			targets[i].Family = *familyFlag
+		}
+		if *downloadFlag {
+			targets[i].Download = true
+		}
+		if targets[i].UploadBytes == 0 && targets[i].Uploads() {
+			targets[i].UploadBytes = *uploadBytes
+		}
+		if len(*headersFlag) > 0 {
+			targets[i].Headers = append(targets[i].Headers, strings.Split(*headersFlag, ",")...)
+		}
+		if *groupCache {
+			targets[i].GroupByCache = true
+		}
+		if *tcpInfoFlag {
+			targets[i].TcpInfo = true
+		}
+		if len(targets[i].Encoding) == 0 {
+			targets[i].Encoding = *encodingFlag
+		}
+		if len(targets[i].Protocol) == 0 {
+			targets[i].Protocol = *protoFlag
+		}
+		if len(targets[i].Resolver) == 0 {
+			targets[i].Resolver = *resolverFlag
+		}
+		if len(targets[i].Load) == 0 {
+			targets[i].Load = *loadFlag
+		}
+		if targets[i].LoadWorkers == 0 {
+			targets[i].LoadWorkers = *loadWorkers
+		}
+		if targets[i].Users == 0 && len(targets[i].Load) == 0 {
+			targets[i].Users = *usersFlag
+		}
+		if targets[i].ThinkMsec == 0 {
+			targets[i].ThinkMsec = *thinkMsec
+		}
+		targets[i].Resolve = append(targets[i].Resolve, resolves...)
+		if err := targets[i].Init(); err != nil {
+			log.Println("Error: target", targets[i].Url+":", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(targets) == 0 {
 		log.Println("Error: no destinations to test")
 		printUsage()
 		os.Exit(1)
@@ -241,9 +428,9 @@ func main() {
 	}
 
 	if delayEnv, found := os.LookupEnv("PERFTEST_DELAY"); found {
-		delay, err := strconv.Atoi(delayEnv)
-		if err != nil || delay < 1 {
-			log.Println("Warning: PERFTEST_DELAY environment is", delayEnv, "-- value must be int > 0.  Using -d", *delayFlag, "instead")
+		delay, err := strconv.ParseFloat(delayEnv, 64)
+		if err != nil || delay <= 0 {
+			log.Println("Warning: PERFTEST_DELAY environment is", delayEnv, "-- value must be a number > 0.  Using -d", *delayFlag, "instead")
 		} else {
 			if wasFlagPassed("d") {
 				log.Println("Note: PERFTEST_DELAY from environment,", delay, "overrides -d", *delayFlag)
@@ -264,6 +451,30 @@ func main() {
 		}
 	}
 
+	storeDir := os.Getenv("PERFTEST_STORE")
+	if len(*storeFlag) > 0 {
+		if len(storeDir) > 0 {
+			log.Println("NOTE: overwriting store directory from env,", storeDir, "via command line")
+		}
+		storeDir = *storeFlag
+	}
+	if len(storeDir) > 0 {
+		keep, err := store.ParseAge(*keepFlag)
+		if err != nil {
+			log.Println("ERROR: cannot parse -k:", err)
+			return
+		}
+		resultStore, err = store.Open(storeDir, store.Options{RawAge: keep, MaxBytes: *storeMBytes << 20})
+		if err != nil {
+			log.Println("ERROR: cannot open store:", err)
+			return
+		}
+		defer resultStore.Close() // writes the last rollups
+		if verbose > 0 {
+			log.Println("storing results in", storeDir)
+		}
+	}
+
 	if *portFlag > 0 {
 		if serverPort > 0 {
 			log.Println("NOTE: command line port", *portFlag, "overrides listen port from env", serverPort)
@@ -283,6 +494,14 @@ func main() {
 		go srv.StartServer(serverPort)
 		// http.HandleFunc("/ping", srv.pongReply)
 		http.HandleFunc("/memstats", srv.MemStatsReply)
+		http.HandleFunc("/stats", srv.StatsReply)
+		http.HandleFunc("/metrics", srv.MetricsReply)
+		srv.SetMaintenance(maintenance, os.Getenv("PERFTEST_ADMIN_TOKEN"))
+		http.HandleFunc("/maintenance", srv.MaintenanceReply)
+		if resultStore != nil {
+			srv.SetStore(resultStore)
+			http.HandleFunc("/history", srv.HistoryReply)
+		}
 	}
 
 	////
@@ -297,21 +516,37 @@ func main() {
 	signal.Notify(sigchan, os.Interrupt)
 	signal.Notify(sigchan, syscall.SIGTERM)
 	go func() {
-		for sig := range sigchan {
-			if verbose > 1 {
-				fmt.Println("\nreceived", sig, "signal, terminating")
-			}
-			if doneChan != nil {
-				close(doneChan)
-				doneChan = nil
-			}
-			close(sigchan)
+		sig := <-sigchan
+		if verbose > 1 {
+			fmt.Println("\nreceived", sig, "signal, terminating")
+		}
+		close(doneChan)
+		for range sigchan {
+			// ignore any more, as when sent to the process group too
 		}
 	}()
 
-	for _, url := range urls {
-		wg.Add(1)                                 // wg.Add must finish before Wait()
-		go testHTTP(url, *numTests, doneChan, wg) // will call wg.Done before it returns
+	window := time.Duration(*windowFlag) * time.Second
+	for i := range targets {
+		if targets[i].IntervalMsec == 0 {
+			targets[i].IntervalMsec = int64(*delayFlag * 1000)
+		}
+		if targets[i].JitterMsec == 0 {
+			targets[i].JitterMsec = *jitterMsec
+		}
+		if *staggerFlag && targets[i].OffsetMsec == 0 {
+			targets[i].OffsetMsec = targets[i].IntervalMsec * int64(i) / int64(len(targets))
+		}
+		summary := pt.NewSummary(&targets[i], myLocation, window)
+		srv.AddSummary(summary)
+		wg.Add(1) // wg.Add must finish before Wait()
+		if len(targets[i].Load) > 0 {
+			go runLoad(&targets[i], summary, doneChan, wg)
+		} else if targets[i].Users > 0 {
+			go runUsers(&targets[i], summary, *numTests, doneChan, wg)
+		} else {
+			go testHTTP(&targets[i], summary, *numTests, doneChan, wg) // will call wg.Done before it returns
+		}
 	}
 
 	// wait for group including ponger if Add(1) preceeds it ...
@@ -321,7 +556,7 @@ func main() {
 
 	if !*jsonFlag {
 		// put header after any debug messages, but there's a race condition here :-)
-		pt.TextHeader(os.Stdout)
+		printTextHeader()
 	}
 
 	wg.Wait()
@@ -332,20 +567,22 @@ func main() {
 	return // do not os.Exit, it will not run deferred (cleanup) functions ... (if any)
 }
 
-// testHTTP sends HTTP request(s) to the given URL and captures detailed timing information.
-// It will repeat the request after a delay interval (in time.Seconds) elapses.
+// testHTTP sends HTTP request(s) to the target URL and captures detailed timing information.
+// It will repeat the request every interval (IntervalMsec), at a fixed rate
+// however long each request takes, with any jitter and start offset.
 // It will make numTries attempts.
 // It will exit if the done channel closes.
+// Results are accumulated into summary, which is reported upon return.
 // Calls WaitGroup.Done upon return so caller knows when all work is finished.
-func testHTTP(uri string, numTries int, done <-chan int, wg *sync.WaitGroup) {
+func testHTTP(target *pt.Target, summary *pt.Summary, numTries int, done <-chan int, wg *sync.WaitGroup) {
 	// clear this task in the waitgroup when returning
 	defer wg.Done()
 	if numTries == 0 {
 		numTries = math.MaxInt32
 	}
 
-	url := pt.ParseURL(uri)
-	urlStr := url.Scheme + "://" + url.Host + url.Path
+	url := pt.ParseURL(target.Url)
+	urlStr := resultUrl(target)
 
 	if verbose > 2 {
 		log.Println("test", urlStr)
@@ -357,114 +594,523 @@ func testHTTP(uri string, numTries int, done <-chan int, wg *sync.WaitGroup) {
 		enc.SetIndent("", "  ")
 	}
 
-	var count int64            // successful
-	failcount := 0             // failed
-	var ptSummary pt.PingTimes // aggregates ping time results
-	mn := "RespTime"           // CloudWatch metric name
-	ns := "Http Perf Demo"     // CloudWatch namespace
+	var count int64 // successful
+	var cycles int  // test cycles with a successful result
+	failcount := 0  // failed
+
+	// Results are grouped by remote address with AllAddrs, by address family
+	// when comparing IPv4 and IPv6, or by cache status with GroupByCache (at
+	// most one, as Init checks), and each group is summarized too.
+	groupBy := ""
+	switch {
+	case target.AllAddrs:
+		groupBy = "Remote"
+	case target.Family == "both":
+		groupBy = "Family"
+	case target.GroupByCache:
+		groupBy = "Cache"
+	}
+
+	msec := func(n int64) time.Duration { return time.Duration(n) * time.Millisecond }
+	sched := target.Schedule(time.Now())
+
+	state := newProbeState(target, summary)
+	byGroup := make(map[string]*probeState) // per group of results
+	var groups []string                     // keys of byGroup, in order first seen
+
+	defer func() { // summary printer, runs upon return
+		if summary.Count() > 0 {
+			printSummary(summary.Report(), enc)
+		}
+		for _, group := range groups {
+			printSummary(byGroup[group].summary.Report(), enc)
+		}
+	}()
 
 	for {
-		ptResult := pt.FetchURL(urlStr, myLocation)
-		if nil == ptResult {
-			failcount++
-			if failcount >= *maxFails {
-				log.Println("fetch failure", failcount, "of", *maxFails, "on", url)
-				// deferred routine below will print summary report if count > 0
-				if count == 0 {
-					fmt.Println("No valid samples received, no summary provided")
+		tick, skipped := sched.Next(time.Now())
+		if skipped > 0 && verbose > 0 {
+			log.Println("skipped", skipped, "test cycles of", urlStr, "as the last took longer than", msec(target.IntervalMsec))
+		}
+		select {
+		case <-done:
+			// channel is closed, we are done -- report statistics and return
+			return
+
+		case <-time.After(time.Until(tick)):
+			// we waited for the tick and the done channel is still open ... keep going
+		}
+
+		if paused, _ := maintenance.Check(target.Url, time.Now()); paused {
+			if verbose > 1 {
+				log.Println("skipped test of", urlStr, "paused for maintenance")
+			}
+			if target.IntervalMsec == 0 && len(target.Cron) == 0 { // no tick to wait for
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Second):
 				}
-				return
 			}
-			// fall out below, check done channel and try again after delay
+			continue
+		}
+
+		var results []*pt.PingTimes
+		if target.AllAddrs {
+			results = pt.FetchEach(target, myLocation)
+		} else if target.Family == "both" {
+			results = pt.FetchFamilies(target, myLocation)
 		} else {
-			if count == 0 {
-				ptSummary = *ptResult
-				defer func() { // summary printer, runs upon return
-					elapsed := hhmmss(time.Now().Unix() - ptSummary.Start.Unix())
-
-					fc := float64(count) // count will be 1 by time this runs
-					fmt.Printf("\nRecorded %d samples in %s, average values:\n"+"%s"+
-						"%d %-6s\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t\t%d\t%s\t%s\n\n",
-						count, elapsed, pt.PingTimesHeader(),
-						count, elapsed,
-						pt.Msec(ptSummary.DnsLk)/fc,
-						pt.Msec(ptSummary.TcpHs)/fc,
-						pt.Msec(ptSummary.TlsHs)/fc,
-						pt.Msec(ptSummary.Reply)/fc,
-						pt.Msec(ptSummary.Close)/fc,
-						pt.Msec(ptSummary.RespTime())/fc,
-						// TODO: report summary stats per response code
-						ptSummary.Size/count,
-						"", // TODO: report summary of each from location?
-						*ptSummary.DestUrl)
-				}()
-				// TODO: report all summaries just before program exit (not thread exit)
-			} else {
-				ptSummary.DnsLk += ptResult.DnsLk
-				ptSummary.TcpHs += ptResult.TcpHs
-				ptSummary.TlsHs += ptResult.TlsHs
-				ptSummary.Reply += ptResult.Reply
-				ptSummary.Close += ptResult.Close
-				ptSummary.Total += ptResult.Total
-				ptSummary.Size += ptResult.Size
-				// TODO: record changes in Remote Server IP from DNS resolution
-				// TODO: record count of different RespCode HTTP response code seen
-				// or keep a summary object in a hash by unique RespCode
-				// (in which case the count is needed in each one)
-			}
-			count++
+			results = []*pt.PingTimes{pt.FetchTarget(target, myLocation)}
+		}
 
-			////
-			//  Print out result of this test
-			////
-			if *jsonFlag {
-				enc.Encode(ptResult)
-			} else {
-				fmt.Println(count, ptResult.MsecTsv())
+		valid := false
+		for _, ptResult := range results {
+			storeResult(urlStr, ptResult)
+			if nil == ptResult {
+				summary.Add(ptResult)
+				if _, silenced := maintenance.Check(target.Url, time.Now()); silenced {
+					continue // an outage during maintenance does not count toward -f
+				}
+				failcount++
+				if failcount >= *maxFails {
+					log.Println("fetch failure", failcount, "of", *maxFails, "on", url)
+					// deferred routine below will print summary report if count > 0
+					if count == 0 {
+						fmt.Println("No valid samples received, no summary provided")
+					}
+					return
+				}
+				// fall out below, check done channel and try again at the next tick
+				continue
 			}
 
-			if *cwFlag {
-				if verbose > 1 {
-					log.Println("publishing", pt.Msec(ptResult.RespTime()), "msec to cloudwatch")
+			st := state
+			if len(groupBy) > 0 {
+				group := ptResult.Remote
+				switch groupBy {
+				case "Family":
+					group = ptResult.Family
+				case "Cache":
+					group = pt.CacheGroup(ptResult)
 				}
-				respCode := "0"
-				if ptResult.RespCode >= 0 {
-					// 000 in cloudwatch indicates it was a zero return code from lower layer
-					// while single digit 0 indicates an error making the request
-					respCode = fmt.Sprintf("%03d", ptResult.RespCode)
+				st = byGroup[group]
+				if st == nil {
+					st = newProbeState(target, pt.NewGroupSummary(target, groupBy, group, myLocation, summary.Window()))
+					srv.AddSummary(st.summary)
+					byGroup[group] = st
+					groups = append(groups, group)
 				}
-
-				cw.PublishRespTime(myLocation, urlStr, respCode, pt.Msec(ptResult.RespTime()), mn, ns)
+				st.record(ptResult)
+				summary.Add(ptResult) // and to the summary of all groups
+			} else {
+				st.record(ptResult)
 			}
 
-			if whClient != nil {
-				if verbose > 1 {
-					log.Println("publishing", ptResult.Remote, "to webhook")
+			valid = true
+			count++
+			if reportResult(target, st, ptResult, count, urlStr, enc) {
+				failcount++
+				if failcount >= *maxFails {
+					log.Println("failure", failcount, "of", *maxFails, "on", url)
+					return
 				}
-				publishJSON(whURL, ptResult)
 			}
+		}
 
-			// check if respose time exceeds threshold
-			if ptResult.RespTime() > alertThresh {
-				// generate any requested alerts
-				sendAlert(ptResult, urlStr)
+		if groupBy == "Family" && len(results) == 2 {
+			v4, v6 := results[0], results[1]
+			if v4 != nil && v6 != nil && !v4.Failed() && !v6.Failed() {
+				summary.AddPair(v4, v6)
+				if verbose > 1 {
+					log.Printf("%s IPv6 - IPv4 response time %.03f msec", urlStr, pt.Msec(v6.RespTime()-v4.RespTime()))
+				}
 			}
 		}
 
-		if count >= int64(numTries) {
+		if valid {
+			cycles++
+		}
+		if cycles >= numTries {
 			// report stats (see deferred func() above) upon return
 			return
 		}
+	} // for ever
+}
 
-		select {
-		case <-done:
-			// channel is closed, we are done -- report statistics and return
-			return
+// runLoad load tests the target at the rates of its Load stages (see pt.RunLoad),
+// adding each result to summary, and prints a report of each stage and the
+// summary when done.  Results are not printed, published or alerted on one by
+// one, as there are too many.  Calls WaitGroup.Done upon return.
+func runLoad(target *pt.Target, summary *pt.Summary, done <-chan int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if verbose > 0 {
+		log.Println("load test", target.Url, "at", target.Load, "with up to", target.LoadWorkers, "requests in flight")
+	}
 
-		case <-time.After(time.Duration(*delayFlag) * time.Second):
-			// we waited for the duration and the done channel is still open ... keep going
+	reports := pt.RunLoad(target, myLocation, target.LoadWorkers, done, addResult(target, summary))
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		for _, r := range reports {
+			enc.Encode(r)
 		}
-	} // for ever
+		printSummary(summary.Report(), enc)
+		return
+	}
+	fmt.Printf("\nLoad test of %s, latency from when each request was due:\n", target.Url)
+	fmt.Printf("# stage\trate/s\tsecs\tsent\terrors\terr%%\tok/s\twait\tservice\tp50\tp90\tp95\tp99\tmax\n")
+	for _, r := range reports {
+		rate := fmt.Sprintf("%g", r.FromRate)
+		if r.ToRate != r.FromRate {
+			rate += fmt.Sprintf("-%g", r.ToRate)
+		}
+		fmt.Printf("%d\t%s\t%.0f\t%d\t%d\t%.2f\t%.1f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\n",
+			r.Stage, rate, r.Duration.Seconds(), r.Sent, r.Errors, 100*r.ErrorRate, r.Throughput,
+			r.Wait, r.Service, r.P50, r.P90, r.P95, r.P99, r.Max)
+	}
+	if summary.Count() > 0 {
+		printSummary(summary.Report(), nil)
+	}
+}
+
+// runUsers load tests the target with its virtual Users (see pt.RunUsers), each
+// making numTries requests, adding each result to summary, and prints a report
+// of each user, of all together with a histogram of response times, and the
+// summary when done.  Calls WaitGroup.Done upon return.
+func runUsers(target *pt.Target, summary *pt.Summary, numTries int, done <-chan int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	think := time.Duration(target.ThinkMsec) * time.Millisecond
+	if verbose > 0 {
+		log.Println("load test", target.Url, "with", target.Users, "virtual users, think time", think)
+	}
+
+	each, all := pt.RunUsers(target, myLocation, target.Users, think, numTries, done, addResult(target, summary))
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		for _, r := range each {
+			enc.Encode(r)
+		}
+		enc.Encode(all)
+		printSummary(summary.Report(), enc)
+		return
+	}
+	fmt.Printf("\nLoad test of %s with %d virtual users, think time %s:\n", target.Url, target.Users, think)
+	fmt.Printf("# user\trequests\terrors\tnew\treused\tsecs\treq/s\tavg\n")
+	for _, r := range append(each, all) {
+		fmt.Printf("%s\t%d\t%d\t%d\t%d\t%.1f\t%.1f\t%.03f\n",
+			userName(r), r.Requests, r.Errors, r.NewConns, r.Reused, r.Elapsed.Seconds(), r.Rate, r.Latency.Mean())
+	}
+	if conns := all.NewConns + all.Reused; conns > 0 {
+		fmt.Printf("Connections: %d new, %d reused (%.1f%%)\n",
+			all.NewConns, all.Reused, 100*float64(all.Reused)/float64(conns))
+	}
+	printUserHistograms(append(each, all))
+	printHistogram(&all.Latency)
+	if summary.Count() > 0 {
+		printSummary(summary.Report(), nil)
+	}
+}
+
+// addResult returns a function that adds a result of the target to summary and
+// stores it, for the results of a load test.
+func addResult(target *pt.Target, summary *pt.Summary) func(*pt.PingTimes) {
+	urlStr := resultUrl(target)
+	return func(ptResult *pt.PingTimes) {
+		summary.Add(ptResult)
+		storeResult(urlStr, ptResult)
+	}
+}
+
+// resultUrl returns the URL of the results from the target, without a query
+// string, except for DNS, where the query is in the parameters.
+func resultUrl(target *pt.Target) string {
+	url := pt.ParseURL(target.Url)
+	if url == nil || url.Scheme == "dns" {
+		return target.Url
+	}
+	return url.Scheme + "://" + url.Host + url.Path
+}
+
+// storeResult adds a result from urlStr to the store, with -B.  A nil result,
+// of a request that could not be made, is stored as a failure with no times.
+func storeResult(urlStr string, ptResult *pt.PingTimes) {
+	if resultStore == nil {
+		return
+	}
+	if ptResult == nil {
+		ptResult = &pt.PingTimes{Start: time.Now(), DestUrl: &urlStr, Location: &myLocation, RespCode: -1}
+	}
+	if err := resultStore.Add(ptResult); err != nil {
+		log.Println("storing result:", err)
+	}
+}
+
+// userName returns the name of a virtual user in reports: its number, or "all".
+func userName(r pt.UserReport) string {
+	if r.User == 0 {
+		return "all"
+	}
+	return fmt.Sprint(r.User)
+}
+
+// printUserHistograms prints the histogram of response times of each virtual
+// user, a line each, over the buckets from the first to the last with a count.
+func printUserHistograms(reports []pt.UserReport) {
+	first, last := -1, -1
+	for _, r := range reports {
+		for i, n := range r.Latency.Counts {
+			if n > 0 {
+				if first < 0 || i < first {
+					first = i
+				}
+				if i > last {
+					last = i
+				}
+			}
+		}
+	}
+	if first < 0 {
+		return
+	}
+	fmt.Printf("# user")
+	for i := first; i <= last; i++ {
+		if i < len(pt.HistogramBuckets) {
+			fmt.Printf("\t<=%g", pt.HistogramBuckets[i])
+		} else {
+			fmt.Printf("\t>%g", pt.HistogramBuckets[i-1])
+		}
+	}
+	fmt.Printf("\tmsec\n")
+	for _, r := range reports {
+		fmt.Print(userName(r))
+		for i := first; i <= last; i++ {
+			var n int64
+			if i < len(r.Latency.Counts) {
+				n = r.Latency.Counts[i]
+			}
+			fmt.Printf("\t%d", n)
+		}
+		fmt.Println()
+	}
+}
+
+// printHistogram prints the buckets of a histogram of response times, from the
+// first to the last with a count, with a bar for the share of each.
+func printHistogram(h *pt.Histogram) {
+	first, last := -1, -1
+	for i, n := range h.Counts {
+		if n > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return
+	}
+	fmt.Printf("Response time histogram:\n")
+	for i := first; i <= last; i++ {
+		bound := "+Inf"
+		if i < len(pt.HistogramBuckets) {
+			bound = fmt.Sprintf("%g", pt.HistogramBuckets[i])
+		}
+		share := float64(h.Counts[i]) / float64(h.Count)
+		fmt.Printf("  <= %6s msec %8d %5.1f%% %s\n", bound, h.Counts[i], 100*share, strings.Repeat("#", int(share*50+0.5)))
+	}
+}
+
+// probeState holds what testHTTP tracks about the results from a target, or with
+// AllAddrs, from one remote address of the target.
+type probeState struct {
+	summary    *pt.Summary
+	detector   *pt.AnomalyDetector
+	lastHashed *pt.PingTimes // last good result with a body hash, to detect changes
+}
+
+func newProbeState(target *pt.Target, summary *pt.Summary) *probeState {
+	st := &probeState{summary: summary}
+	if target.AnomalySigmas > 0 {
+		st.detector = pt.NewAnomalyDetector(target.AnomalySigmas)
+	}
+	return st
+}
+
+// record checks a result for anomalies and adds it to the summary.
+func (st *probeState) record(ptResult *pt.PingTimes) {
+	if st.detector != nil {
+		st.detector.Check(ptResult) // before Add, which counts anomalies
+	}
+	st.summary.Add(ptResult)
+}
+
+// printTextHeader prints the column header of the text output: those of
+// PingTimes and a servertiming column.
+func printTextHeader() {
+	fmt.Print(strings.TrimSuffix(pt.PingTimesHeader(), "\n"), "\tservertiming\n")
+}
+
+// reportResult prints a result, publishes it to CloudWatch and the webhook as
+// configured, and sends any alerts it calls for.  Returns true if the result
+// failed (see PingTimes.Failed), counting toward the -f limit, which none does
+// while the alerts of the target are silenced for maintenance.
+func reportResult(target *pt.Target, st *probeState, ptResult *pt.PingTimes, count int64, urlStr string, enc *json.Encoder) bool {
+	mn := "RespTime"       // CloudWatch metric name
+	ns := "Http Perf Demo" // CloudWatch namespace
+
+	////
+	//  Print out result of this test
+	////
+	if enc != nil {
+		enc.Encode(ptResult)
+	} else {
+		fmt.Println(count, ptResult.MsecTsv()+"\t"+ptResult.ServerTimingTsv())
+	}
+	if enc == nil {
+		for i, step := range ptResult.Steps { // of a transaction
+			fmt.Printf("%d.%d %s\n", count, i+1, step.MsecTsv())
+		}
+	}
+
+	if *cwFlag {
+		if verbose > 1 {
+			log.Println("publishing", pt.Msec(ptResult.RespTime()), "msec to cloudwatch")
+		}
+		respCode := "0"
+		if ptResult.RespCode >= 0 {
+			// 000 in cloudwatch indicates it was a zero return code from lower layer
+			// while single digit 0 indicates an error making the request
+			respCode = fmt.Sprintf("%03d", ptResult.RespCode)
+		}
+
+		cw.PublishRespTime(myLocation, urlStr, respCode, pt.Msec(ptResult.RespTime()), mn, ns)
+		for _, m := range ptResult.ServerTiming {
+			if m.Dur > 0 {
+				cw.PublishRespTime(myLocation, urlStr, respCode, pt.Msec(m.Dur), "ServerTiming-"+m.Name, ns)
+			}
+		}
+		r := st.summary.Report()
+		cw.PublishApdex(myLocation, urlStr, r.Apdex.Score, r.RecentApdex.Score, ns)
+	}
+
+	if whClient != nil {
+		if verbose > 1 {
+			log.Println("publishing", ptResult.Remote, "to webhook")
+		}
+		publishJSON(whURL, ptResult)
+	}
+
+	var changedFrom *pt.PingTimes // previous result, if the body changed since
+	if len(ptResult.BodySha256) > 0 && !ptResult.Failed() {
+		if st.lastHashed != nil && st.lastHashed.BodySha256 != ptResult.BodySha256 {
+			changedFrom = st.lastHashed
+		}
+		st.lastHashed = ptResult
+	}
+
+	tlsFailed := ptResult.TLS != nil && len(ptResult.TLS.VerifyError) > 0
+	certWarn := time.Duration(target.CertWarnDays) * 24 * time.Hour
+	if target.AllAddrs {
+		urlStr += " at " + ptResult.Remote
+	} else if len(ptResult.Family) > 0 {
+		urlStr += " over " + ptResult.Family
+	}
+
+	// check if respose time exceeds threshold, or other reasons to alert, and
+	// add any anomalies to the alert for the first reason
+	msg := ""
+	_, silenced := maintenance.Check(target.Url, ptResult.Start)
+	if silenced {
+		if verbose > 1 {
+			log.Println("alerts on", urlStr, "silenced for maintenance")
+		}
+	} else if len(ptResult.AssertFailures) > 0 {
+		msg = fmt.Sprintf("Assertion failed on %s: %s", urlStr, strings.Join(ptResult.AssertFailures, ", "))
+	} else if tlsFailed {
+		msg = fmt.Sprintf("TLS verification failed on %s: %s", urlStr, ptResult.TLS.VerifyError)
+	} else if ptResult.Failed() {
+		msg = failureMessage(ptResult, urlStr)
+	} else if changedFrom != nil {
+		msg = contentChangeMessage(changedFrom, ptResult, urlStr)
+	} else if certWarn > 0 && ptResult.TLS != nil && ptResult.TLS.ExpiresWithin(ptResult.Start, certWarn) {
+		days := int(ptResult.TLS.NotAfter.Sub(ptResult.Start).Hours() / 24)
+		msg = fmt.Sprintf("Certificate of %s expires in %d days, %s", urlStr, days, ptResult.TLS.NotAfter.Format(time.RFC3339))
+	} else if ptResult.RespTime() > alertThresh {
+		msg = fmt.Sprintf("RespTime %s on %s exceeds %s", ptResult.RespTime(), urlStr, alertThresh)
+	}
+	if !silenced && len(ptResult.Anomalies) > 0 {
+		if len(msg) > 0 {
+			msg += "; " + anomalyMessage(ptResult.Anomalies, urlStr)
+		} else {
+			msg = anomalyMessage(ptResult.Anomalies, urlStr)
+		}
+	}
+	if len(msg) > 0 {
+		sendAlert(ptResult, msg)
+	}
+
+	return !silenced && ptResult.Failed()
+}
+
+// printSummary writes the summary report for a target to stdout: as JSON if
+// enc is non-nil, or else as text averages in the same columns as the samples.
+func printSummary(r pt.SummaryReport, enc *json.Encoder) {
+	if enc != nil {
+		enc.Encode(r)
+		return
+	}
+
+	elapsed := hhmmss(int64(r.Elapsed / time.Second))
+	remote := ""
+	if len(r.GroupBy) > 0 {
+		fmt.Printf("\n%s %s:", r.GroupBy, r.Group)
+		if r.GroupBy == "Remote" {
+			remote = r.Group
+		}
+	}
+	fmt.Printf("\nRecorded %d samples in %s, average values:\n"+"%s"+
+		"%d %-6s\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t\t%d\t%s\t%s\t%s\n",
+		r.Count, elapsed, pt.PingTimesHeader(),
+		r.Count, elapsed,
+		r.DnsLk,
+		r.TcpHs,
+		r.TlsHs,
+		r.Reply,
+		r.Close,
+		r.Total,
+		// TODO: report summary stats per response code
+		r.Size,
+		"", // TODO: report summary of each from location?
+		remote,
+		r.Url)
+	fmt.Printf("Apdex[T=%s] %.03f %s, last %s %.03f %s\n\n",
+		r.Apdex.T, r.Apdex.Score, apdexCounts(r.Apdex),
+		r.RecentWidth, r.RecentApdex.Score, apdexCounts(r.RecentApdex))
+	if r.Pairs > 0 {
+		fmt.Printf("IPv6 - IPv4 response time %+.03f msec, average of %d pairs\n\n", r.V6Delta, r.Pairs)
+	}
+	if r.Throughput > 0 {
+		fmt.Printf("Download throughput %.0f bytes/sec\n\n", r.Throughput)
+	}
+	if r.UploadThroughput > 0 {
+		fmt.Printf("Upload throughput %.0f bytes/sec\n\n", r.UploadThroughput)
+	}
+	if len(r.ServerTiming) > 0 {
+		fmt.Printf("Server-Timing averages, of Reply %.03f msec:\n", r.Reply)
+		for _, m := range r.ServerTiming {
+			fmt.Printf("  %-16s %.03f msec in %d samples\n", m.Name, m.Mean(), m.Count)
+		}
+		fmt.Println()
+	}
+}
+
+// apdexCounts formats the satisfied/tolerating/frustrated sample counts.
+func apdexCounts(a pt.ApdexScore) string {
+	return fmt.Sprintf("(S/T/F %d/%d/%d)", a.Satisfied, a.Tolerating, a.Frustrated)
 }
 
 func hhmmss(secs int64) string {
@@ -482,6 +1128,83 @@ func hhmmss(secs int64) string {
 	return fmt.Sprintf("%ds", secs)
 }
 
+// runQuery prints results from the store of a perftest run with -B, or rollups
+// of them, for the "perftest query" command, and returns the exit status.
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dir := fs.String("B", os.Getenv("PERFTEST_STORE"), "directory of the store of results (default PERFTEST_STORE)")
+	urlFlag := fs.String("url", "", "URL of the results (default all)")
+	fromFlag := fs.String("from", "1h", "start of the results: an age like 6h or 7d, an RFC 3339 time, or Unix seconds")
+	toFlag := fs.String("to", "now", "end of the results, like -from")
+	resFlag := fs.String("res", "", "resolution: raw for each result, or 1m or 1h for rollups (default 1m up to a day, or else 1h)")
+	asJson := fs.Bool("j", false, "write JSON (default is text TSV format)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s query [flags]\nPrints results stored with -B, or rollups of them per minute or hour.\n\nflags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if len(*dir) == 0 {
+		log.Println("Error: query needs the store directory, with -B or PERFTEST_STORE")
+		return 2
+	}
+	s, err := store.OpenReadOnly(*dir)
+	if err != nil {
+		log.Println("Error:", err)
+		return 1
+	}
+	now := time.Now()
+	from, err := store.ParseTime(*fromFlag, now)
+	if err != nil {
+		log.Println("Error: -from:", err)
+		return 2
+	}
+	to, err := store.ParseTime(*toFlag, now)
+	if err != nil {
+		log.Println("Error: -to:", err)
+		return 2
+	}
+	step, err := store.ParseResolution(*resFlag, from, to)
+	if err != nil {
+		log.Println("Error: -res:", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if step == 0 {
+		results, err := s.Results(*urlFlag, from, to, 0)
+		if err != nil {
+			log.Println("Error:", err)
+			return 1
+		}
+		if *asJson {
+			enc.Encode(results)
+			return 0
+		}
+		printTextHeader()
+		for _, p := range results {
+			fmt.Println(p.MsecTsv() + "\t" + p.ServerTimingTsv())
+		}
+		return 0
+	}
+
+	rollups, err := s.Rollups(step, *urlFlag, from, to)
+	if err != nil {
+		log.Println("Error:", err)
+		return 1
+	}
+	if *asJson {
+		enc.Encode(rollups)
+		return 0
+	}
+	fmt.Print(store.RollupHeader())
+	for i := range rollups {
+		fmt.Println(rollups[i].Tsv())
+	}
+	return 0
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////
 //  Alert management
 ////////////////////////////////////////////////////////////////////////////////////////
@@ -489,9 +1212,10 @@ func hhmmss(secs int64) string {
 // Unix time of last alert ... to compare to
 var lastAlert int64
 
-func sendAlert(ptResult *pt.PingTimes, url string) {
+// sendAlert sends msg about the result to the configured alert receivers, unless
+// an alert was sent less than alertInterval before.
+func sendAlert(ptResult *pt.PingTimes, msg string) {
 	timeSinceLast := ptResult.Start.Unix() - lastAlert
-	msg := fmt.Sprintf("RespTime %s on %s exceeds %s", ptResult.RespTime(), url, alertThresh)
 	if verbose > 0 {
 		log.Println(msg)
 	}
@@ -505,7 +1229,7 @@ func sendAlert(ptResult *pt.PingTimes, url string) {
 	lastAlert = ptResult.Start.Unix()
 
 	if 0 == len(twilioKey) || 0 == len(twilioSms) {
-		log.Println("OOPS: nowhere to send notification for", url)
+		log.Println("OOPS: nowhere to send notification for", pt.SafeStrPtr(ptResult.DestUrl, "noUrl"))
 	} else {
 		for _, sms := range twilioSms {
 			sendTwilio(msg, twilioKey, sms)
@@ -513,6 +1237,42 @@ func sendAlert(ptResult *pt.PingTimes, url string) {
 	}
 }
 
+// contentChangeMessage describes a change in the body hash between results.
+func contentChangeMessage(prev, cur *pt.PingTimes, url string) string {
+	short := func(sum string) string {
+		if len(sum) > 12 {
+			return sum[:12]
+		}
+		return sum
+	}
+	msg := fmt.Sprintf("Content changed on %s: SHA-256 %s to %s", url, short(prev.BodySha256), short(cur.BodySha256))
+	if prev.ETag != cur.ETag {
+		msg += fmt.Sprintf(", ETag %s to %s", prev.ETag, cur.ETag)
+	}
+	if prev.LastModified != cur.LastModified {
+		msg += fmt.Sprintf(", Last-Modified %s to %s", prev.LastModified, cur.LastModified)
+	}
+	return msg
+}
+
+// failureMessage describes a failed request without a more specific reason:
+// one that could not be made, or an error status.
+func failureMessage(ptResult *pt.PingTimes, url string) string {
+	if ptResult.RespCode == 520 || ptResult.RespCode < 0 {
+		return fmt.Sprintf("Request failed on %s: no response", url)
+	}
+	return fmt.Sprintf("Request failed on %s: status %d", url, ptResult.RespCode)
+}
+
+// anomalyMessage describes the anomalies found in a result from url.
+func anomalyMessage(anomalies []pt.Anomaly, url string) string {
+	var parts []string
+	for _, a := range anomalies {
+		parts = append(parts, fmt.Sprintf("%s %.03f msec is %.1f sigma over %.03f", a.Phase, a.Value, a.Sigmas, a.Mean))
+	}
+	return fmt.Sprintf("Anomaly on %s: %s", url, strings.Join(parts, ", "))
+}
+
 func sendTwilio(msg, key, sms string) {
 	separator := strings.Index(key, ":")
 	if -1 == separator {
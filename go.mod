@@ -1,9 +1,23 @@
 module github.com/rafayopen/perftest
 
-go 1.12
+go 1.23
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/aws/aws-sdk-go v1.21.3
-	github.com/stretchr/testify v1.3.0 // indirect
-	golang.org/x/net v0.0.0-20190724013045-ca1201d0de80 // indirect
+	github.com/klauspost/compress v1.10.10
+	github.com/quic-go/quic-go v0.54.1
+	golang.org/x/net v0.28.0
+)
+
+require (
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
@@ -60,3 +60,47 @@ func PublishRespTime(location, url, respCode string, metricValue float64, metric
 		log.Println("Error publishing", url, "from", location, "to cloudwatch:", err)
 	}
 }
+
+// PublishApdex publishes the Apdex score of a target to a CloudWatch namespace,
+// both cumulative ("Apdex") and over the recent rolling window ("ApdexRecent").
+// Uses the same environment and dimensions as PublishRespTime, apart from the
+// response code.
+func PublishApdex(location, url string, apdex, recent float64, namespace string) {
+	sess := session.Must(session.NewSession())
+	svc := cloudwatch.New(sess)
+
+	timestamp := time.Now()
+	dimensions := []*cloudwatch.Dimension{
+		&cloudwatch.Dimension{
+			Name:  aws.String("TestUrl"),
+			Value: aws.String(url),
+		},
+		&cloudwatch.Dimension{
+			Name:  aws.String("FromLocation"),
+			Value: aws.String(pt.LocationOrIp(&location)),
+		},
+	}
+	_, err := svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(namespace),
+
+		MetricData: []*cloudwatch.MetricDatum{
+			&cloudwatch.MetricDatum{
+				Timestamp:  &timestamp,
+				MetricName: aws.String("Apdex"),
+				Value:      aws.Float64(apdex),
+				Unit:       aws.String(cloudwatch.StandardUnitNone),
+				Dimensions: dimensions,
+			},
+			&cloudwatch.MetricDatum{
+				Timestamp:  &timestamp,
+				MetricName: aws.String("ApdexRecent"),
+				Value:      aws.Float64(recent),
+				Unit:       aws.String(cloudwatch.StandardUnitNone),
+				Dimensions: dimensions,
+			},
+		},
+	})
+	if err != nil {
+		log.Println("Error publishing Apdex for", url, "from", location, "to cloudwatch:", err)
+	}
+}
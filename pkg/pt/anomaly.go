@@ -0,0 +1,137 @@
+package pt
+
+//  Anomaly detection against a learned baseline of each time component
+
+import (
+	"math"
+	"time"
+)
+
+// Ewma tracks the exponentially weighted moving average and variance of a
+// series of values.  Alpha is the weight of each new value: 0.1 gives the
+// last twenty or so values most of the influence.
+type Ewma struct {
+	Alpha float64
+	Mean  float64
+	Var   float64
+	N     int64 // number of values added
+}
+
+// Add updates the moving average and variance with value x.
+func (e *Ewma) Add(x float64) {
+	e.AddWeighted(x, e.Alpha)
+}
+
+// AddWeighted updates the moving average and variance with value x, at weight
+// alpha instead of Alpha.
+func (e *Ewma) AddWeighted(x, alpha float64) {
+	e.N++
+	if e.N == 1 {
+		e.Mean = x
+		return
+	}
+	diff := x - e.Mean
+	incr := alpha * diff
+	e.Mean += incr
+	e.Var = (1 - alpha) * (e.Var + diff*incr)
+}
+
+// StdDev returns the moving standard deviation.
+func (e *Ewma) StdDev() float64 {
+	return math.Sqrt(e.Var)
+}
+
+// Anomaly describes a time component of a PingTimes result that was unusually
+// slow compared to the baseline learned from previous results.  Values are in
+// milliseconds.
+type Anomaly struct {
+	Phase  string  // column name of the time component, as in PingTimesHeader
+	Value  float64 // value of this sample
+	Mean   float64 // baseline mean
+	StdDev float64 // baseline standard deviation
+	Sigmas float64 // how many standard deviations Value is over Mean
+}
+
+// Defaults for AnomalyDetector.
+const (
+	AnomalyAlpha        = 0.1  // EWMA weight of each new sample
+	AnomalyFlaggedAlpha = 0.01 // EWMA weight of a sample flagged as an anomaly
+	AnomalyWarmup       = 10   // samples to learn from before flagging anomalies
+)
+
+// anomalyFloor is the smallest standard deviation used to judge a sample, as a
+// fraction of the mean and as an absolute value, so a component that is nearly
+// constant (DNS served from cache, for example) doesn't flag tiny variations.
+const (
+	anomalyFloorFraction = 0.05
+	anomalyFloorMsec     = 0.1
+)
+
+// phases lists the time components checked for anomalies.
+var phases = []struct {
+	name  string
+	value func(pt *PingTimes) time.Duration
+}{
+	{"DNS", func(pt *PingTimes) time.Duration { return pt.DnsLk }},
+	{"TCP", func(pt *PingTimes) time.Duration { return pt.TcpHs }},
+	{"TLS", func(pt *PingTimes) time.Duration { return pt.TlsHs }},
+	{"First", func(pt *PingTimes) time.Duration { return pt.Reply }},
+	{"LastB", func(pt *PingTimes) time.Duration { return pt.Close }},
+	{"Total", func(pt *PingTimes) time.Duration { return pt.RespTime() }},
+}
+
+// AnomalyDetector learns a baseline of each time component of the results from
+// one target and flags results that are more than Sigmas standard deviations
+// slower than the baseline.  Faster than usual is not considered an anomaly.
+type AnomalyDetector struct {
+	Sigmas float64 // threshold in standard deviations
+	Warmup int64   // samples to learn before flagging anomalies
+	phases []Ewma
+}
+
+// NewAnomalyDetector returns a detector flagging results over sigmas.
+func NewAnomalyDetector(sigmas float64) *AnomalyDetector {
+	d := &AnomalyDetector{
+		Sigmas: sigmas,
+		Warmup: AnomalyWarmup,
+		phases: make([]Ewma, len(phases)),
+	}
+	for i := range d.phases {
+		d.phases[i].Alpha = AnomalyAlpha
+	}
+	return d
+}
+
+// Check compares each time component of the result to its baseline, records
+// any anomalies in pt.Anomalies, and then adds the result to the baselines.  A
+// component flagged as an anomaly is added at the low weight AnomalyFlaggedAlpha,
+// so a burst of slow samples doesn't raise the baseline and hide the rest of
+// the burst, but a lasting shift in response time still becomes the baseline in
+// time.  Failed results are ignored.
+// Returns the number of anomalies found.
+func (d *AnomalyDetector) Check(pt *PingTimes) int {
+	if pt == nil || pt.Failed() {
+		return 0
+	}
+
+	for i, phase := range phases {
+		e := &d.phases[i]
+		value := Msec(phase.value(pt))
+		alpha := e.Alpha
+		if e.N >= d.Warmup {
+			sd := math.Max(e.StdDev(), math.Max(anomalyFloorFraction*e.Mean, anomalyFloorMsec))
+			if sigmas := (value - e.Mean) / sd; sigmas > d.Sigmas {
+				alpha = AnomalyFlaggedAlpha
+				pt.Anomalies = append(pt.Anomalies, Anomaly{
+					Phase:  phase.name,
+					Value:  value,
+					Mean:   e.Mean,
+					StdDev: e.StdDev(),
+					Sigmas: sigmas,
+				})
+			}
+		}
+		e.AddWeighted(value, alpha)
+	}
+	return len(pt.Anomalies)
+}
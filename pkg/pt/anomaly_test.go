@@ -0,0 +1,33 @@
+package pt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnomalyBurst(t *testing.T) {
+	d := NewAnomalyDetector(3)
+	sample := func(msec float64) *PingTimes {
+		return &PingTimes{Reply: time.Duration(msec * float64(time.Millisecond)), RespCode: 200}
+	}
+	for i := 0; i < 50; i++ {
+		d.Check(sample(100 + float64(i%5)))
+	}
+
+	// every sample of a burst is flagged, not only the first few
+	for i := 0; i < 10; i++ {
+		if d.Check(sample(300)) == 0 {
+			t.Fatalf("slow sample %d of a burst not flagged", i+1)
+		}
+	}
+	// and a lasting shift becomes the baseline
+	flagged := 0
+	for i := 0; i < 1000; i++ {
+		if d.Check(sample(300)) > 0 {
+			flagged++
+		}
+	}
+	if d.Check(sample(300)) > 0 {
+		t.Errorf("still flagged after %d samples of a lasting shift", flagged)
+	}
+}
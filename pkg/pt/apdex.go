@@ -0,0 +1,108 @@
+package pt
+
+//  Apdex (Application Performance Index) scoring, see https://www.apdex.org/
+
+import (
+	"time"
+)
+
+// Apdex counts samples as satisfied (response time <= T), tolerating (<= 4T),
+// or frustrated (slower than 4T, or failed).  The score is
+// (satisfied + tolerating/2) / samples, from 0 (all frustrated) to 1.
+type Apdex struct {
+	T          time.Duration // threshold for a satisfied response
+	Satisfied  int64
+	Tolerating int64
+	Frustrated int64
+}
+
+// Apdex sample classes, as returned by Apdex.Classify.
+const (
+	ApdexSatisfied = iota
+	ApdexTolerating
+	ApdexFrustrated
+)
+
+// Classify returns the Apdex class of a result.  A nil result (the request
+// could not be made) or a failed request counts as frustrated.
+func (a *Apdex) Classify(pt *PingTimes) int {
+	switch {
+	case pt == nil || pt.Failed():
+		return ApdexFrustrated
+	case pt.RespTime() <= a.T:
+		return ApdexSatisfied
+	case pt.RespTime() <= 4*a.T:
+		return ApdexTolerating
+	}
+	return ApdexFrustrated
+}
+
+// Add counts a result in the appropriate class.
+func (a *Apdex) Add(pt *PingTimes) {
+	a.count(a.Classify(pt), 1)
+}
+
+func (a *Apdex) count(class int, n int64) {
+	switch class {
+	case ApdexSatisfied:
+		a.Satisfied += n
+	case ApdexTolerating:
+		a.Tolerating += n
+	default:
+		a.Frustrated += n
+	}
+}
+
+// Samples returns the number of results counted.
+func (a *Apdex) Samples() int64 {
+	return a.Satisfied + a.Tolerating + a.Frustrated
+}
+
+// Score returns the Apdex score, or zero if no samples have been counted
+// (check Samples to tell that apart from a score of zero).
+func (a *Apdex) Score() float64 {
+	n := a.Samples()
+	if n == 0 {
+		return 0
+	}
+	return (float64(a.Satisfied) + float64(a.Tolerating)/2) / float64(n)
+}
+
+// ApdexWindow computes Apdex over a rolling time window, for example the last
+// five minutes, so a recent problem isn't masked by a long healthy history.
+type ApdexWindow struct {
+	Apdex                 // threshold and counts of samples now in the window
+	Width   time.Duration // how far back the window reaches
+	samples []windowSample
+}
+
+type windowSample struct {
+	at    time.Time
+	class int
+}
+
+// NewApdexWindow returns an ApdexWindow with threshold t and the given width.
+func NewApdexWindow(t, width time.Duration) *ApdexWindow {
+	return &ApdexWindow{Apdex: Apdex{T: t}, Width: width}
+}
+
+// Add counts a result recorded at the given time, and ages out old samples.
+func (w *ApdexWindow) Add(at time.Time, pt *PingTimes) {
+	class := w.Classify(pt)
+	w.samples = append(w.samples, windowSample{at, class})
+	w.count(class, 1)
+	w.Expire(at)
+}
+
+// Expire removes samples older than Width before now from the window.
+func (w *ApdexWindow) Expire(now time.Time) {
+	cutoff := now.Add(-w.Width)
+	old := 0
+	for old < len(w.samples) && w.samples[old].at.Before(cutoff) {
+		w.count(w.samples[old].class, -1)
+		old++
+	}
+	if old > 0 {
+		w.samples = append(w.samples[:0], w.samples[old:]...)
+	}
+}
@@ -0,0 +1,74 @@
+package pt
+
+import (
+	"testing"
+	"time"
+)
+
+// timed returns a result of status code with a response time of d.
+func timed(d time.Duration, code int) *PingTimes {
+	return &PingTimes{Total: d, RespCode: code}
+}
+
+func TestApdexClassify(t *testing.T) {
+	a := Apdex{T: 100 * time.Millisecond}
+	ms := time.Millisecond
+	tests := []struct {
+		pt    *PingTimes
+		class int
+	}{
+		{timed(1*ms, 200), ApdexSatisfied},
+		{timed(100*ms, 200), ApdexSatisfied}, // at T
+		{timed(100*ms+1, 200), ApdexTolerating},
+		{timed(400*ms, 200), ApdexTolerating}, // at 4T
+		{timed(400*ms+1, 200), ApdexFrustrated},
+		{nil, ApdexFrustrated},              // not made
+		{timed(1*ms, 520), ApdexFrustrated}, // failed, however fast
+		{timed(1*ms, 404), ApdexFrustrated}, // an HTTP error
+		{&PingTimes{Total: ms, RespCode: 200, AssertFailures: []string{"no"}}, ApdexFrustrated},
+	}
+	for i, test := range tests {
+		if class := a.Classify(test.pt); class != test.class {
+			t.Errorf("result %d: class %d, want %d", i, class, test.class)
+		}
+	}
+
+	for _, test := range tests {
+		a.Add(test.pt)
+	}
+	if a.Satisfied != 2 || a.Tolerating != 2 || a.Frustrated != 5 || a.Samples() != 9 {
+		t.Errorf("counted %+v, want 2 satisfied, 2 tolerating and 5 frustrated", a)
+	}
+	if score := a.Score(); score != 3.0/9 {
+		t.Errorf("score %g, want %g", score, 3.0/9)
+	}
+	if score := (&Apdex{}).Score(); score != 0 {
+		t.Errorf("score %g without samples, want 0", score)
+	}
+}
+
+func TestApdexWindow(t *testing.T) {
+	w := NewApdexWindow(100*time.Millisecond, time.Minute)
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	sec := func(n int) time.Time { return start.Add(time.Duration(n) * time.Second) }
+
+	w.Add(sec(0), nil)                           // frustrated
+	w.Add(sec(30), timed(time.Second, 200))      // frustrated
+	w.Add(sec(50), timed(time.Millisecond, 200)) // satisfied
+	if w.Samples() != 3 || w.Frustrated != 2 {
+		t.Fatalf("window %+v, want 3 samples, 2 frustrated", w.Apdex)
+	}
+	// a minute on, the first is out, and at the limit the second is still in
+	w.Add(sec(90), timed(time.Millisecond, 200))
+	if w.Samples() != 3 || w.Frustrated != 1 || w.Satisfied != 2 {
+		t.Errorf("window %+v after a minute, want 3 samples, 1 frustrated", w.Apdex)
+	}
+	w.Expire(sec(200))
+	if w.Samples() != 0 || w.Score() != 0 {
+		t.Errorf("window %+v after all expired, want no samples", w.Apdex)
+	}
+	w.Add(sec(201), timed(200*time.Millisecond, 200))
+	if w.Samples() != 1 || w.Score() != 0.5 {
+		t.Errorf("window %+v with one tolerating, want a score of 0.5", w.Apdex)
+	}
+}
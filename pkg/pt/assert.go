@@ -0,0 +1,253 @@
+package pt
+
+//  Assertions on the content of a response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxAssertBody is the most response body bytes kept for body assertions.
+// Larger bodies are still read to the end, but only this much is checked.
+const MaxAssertBody = 1 << 20
+
+// Assertions are checks on the response from a target.  A response failing any
+// of them is recorded in PingTimes.AssertFailures and counts as a failure.
+// Zero values are not checked.
+type Assertions struct {
+	Status       []int             // acceptable HTTP status codes
+	BodyContains string            // substring the body must contain
+	BodyRegex    string            // regular expression the body must match
+	JsonPath     map[string]string // JSON path (like "$.data.items[0].id") to expected value
+	Headers      map[string]string // header name to regexp its value must match ("" just requires it)
+	MinSize      int64             // smallest acceptable body size
+	MaxSize      int64             // largest acceptable body size
+	Sha256       string            // expected SHA-256 of the body, in hex
+	Rcode        string            // expected DNS response code, like "NameError" (default "Success")
+	Answers      []string          // answers a DNS probe must include
+
+	bodyRegex *regexp.Regexp
+	headers   map[string]*regexp.Regexp
+}
+
+// compile checks and compiles the regular expressions in the assertions.
+func (a *Assertions) compile() error {
+	var err error
+	if len(a.BodyRegex) > 0 {
+		if a.bodyRegex, err = regexp.Compile(a.BodyRegex); err != nil {
+			return fmt.Errorf("BodyRegex: %v", err)
+		}
+	}
+	a.headers = make(map[string]*regexp.Regexp)
+	for name, expr := range a.Headers {
+		if a.headers[name], err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("Headers %s: %v", name, err)
+		}
+	}
+	for path := range a.JsonPath {
+		if _, err := parseJsonPath(path); err != nil {
+			return fmt.Errorf("JsonPath %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// isZero returns true if no assertion is given.
+func (a *Assertions) isZero() bool {
+	return len(a.Status) == 0 && len(a.BodyContains) == 0 && len(a.BodyRegex) == 0 &&
+		len(a.JsonPath) == 0 && len(a.Headers) == 0 && a.MinSize == 0 && a.MaxSize == 0 &&
+		len(a.Sha256) == 0 && len(a.Rcode) == 0 && len(a.Answers) == 0
+}
+
+// needsBody returns true if the assertions check the response body content.
+func (a *Assertions) needsBody() bool {
+	return len(a.BodyContains) > 0 || len(a.BodyRegex) > 0 || len(a.JsonPath) > 0
+}
+
+// Check returns a description of each assertion the response fails, given the
+// result of the request (with RespCode, Size and BodySha256 filled in), the
+// response headers, and the body (up to MaxAssertBody).
+func (a *Assertions) Check(pt *PingTimes, header http.Header, body []byte) []string {
+	var failed []string
+	status, size := pt.RespCode, pt.Size
+	if len(a.Status) > 0 {
+		ok := false
+		for _, s := range a.Status {
+			ok = ok || s == status
+		}
+		if !ok {
+			failed = append(failed, fmt.Sprintf("status %d not in %v", status, a.Status))
+		}
+	}
+
+	if a.MinSize > 0 && size < a.MinSize {
+		failed = append(failed, fmt.Sprintf("size %d under %d", size, a.MinSize))
+	}
+	if a.MaxSize > 0 && size > a.MaxSize {
+		failed = append(failed, fmt.Sprintf("size %d over %d", size, a.MaxSize))
+	}
+
+	if len(a.Sha256) > 0 && !strings.EqualFold(a.Sha256, pt.BodySha256) {
+		failed = append(failed, fmt.Sprintf("body SHA-256 %s is not %s", pt.BodySha256, a.Sha256))
+	}
+
+	for _, name := range sortedKeys(a.Headers) {
+		values, found := header[http.CanonicalHeaderKey(name)]
+		if !found {
+			failed = append(failed, fmt.Sprintf("header %s missing", name))
+			continue
+		}
+		if re := a.headers[name]; re != nil && !re.MatchString(strings.Join(values, ", ")) {
+			failed = append(failed, fmt.Sprintf("header %s %q does not match %q", name, strings.Join(values, ", "), a.Headers[name]))
+		}
+	}
+
+	if len(a.BodyContains) > 0 && !bytes.Contains(body, []byte(a.BodyContains)) {
+		failed = append(failed, fmt.Sprintf("body does not contain %q", a.BodyContains))
+	}
+	if a.bodyRegex != nil && !a.bodyRegex.Match(body) {
+		failed = append(failed, fmt.Sprintf("body does not match %q", a.BodyRegex))
+	}
+
+	if len(a.JsonPath) > 0 {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			failed = append(failed, fmt.Sprintf("body is not JSON: %v", err))
+		} else {
+			for _, path := range sortedKeys(a.JsonPath) {
+				want := a.JsonPath[path]
+				got, err := JsonPathValue(doc, path)
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+				} else if got != want {
+					failed = append(failed, fmt.Sprintf("%s is %q, not %q", path, got, want))
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// CheckDNS returns a description of each assertion the answer to a DNS probe
+// fails: an rcode other than Rcode (or Success), or missing any of the expected
+// answers.  Names match with or without the trailing dot, in any case.
+func (a *Assertions) CheckDNS(info *DNSInfo, expect []string) []string {
+	var failed []string
+	rcode := a.Rcode
+	if len(rcode) == 0 {
+		rcode = "Success"
+	}
+	if !strings.EqualFold(info.Rcode, rcode) {
+		failed = append(failed, fmt.Sprintf("rcode %s is not %s", info.Rcode, rcode))
+	}
+
+	got := make(map[string]bool)
+	for _, answer := range info.Answers {
+		got[strings.ToLower(strings.TrimSuffix(answer, "."))] = true
+	}
+	for _, want := range expect {
+		if !got[strings.ToLower(strings.TrimSuffix(want, "."))] {
+			failed = append(failed, fmt.Sprintf("answer %s missing from %v", want, info.Answers))
+		}
+	}
+	return failed
+}
+
+// sortedKeys returns the keys of m in order, so failures are reported consistently.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseJsonPath splits a path like "$.data.items[0].id" (the leading "$." is
+// optional) into its keys and array indexes: ["data" "items" "0" "id"].
+func parseJsonPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	var keys []string
+	for _, part := range strings.Split(path, ".") {
+		for {
+			open := strings.Index(part, "[")
+			if open < 0 {
+				break
+			}
+			close := strings.Index(part, "]")
+			if close < open {
+				return nil, fmt.Errorf("missing ] in %q", part)
+			}
+			if open > 0 {
+				keys = append(keys, part[:open])
+			}
+			keys = append(keys, part[open+1:close])
+			part = part[close+1:]
+		}
+		if len(part) > 0 {
+			keys = append(keys, part)
+		}
+	}
+	return keys, nil
+}
+
+// JsonPathValue returns the value at path in a document decoded with UseNumber.
+// Strings are returned as is; other values (numbers, booleans, null, objects
+// and arrays) are returned as JSON.
+func JsonPathValue(doc interface{}, path string) (string, error) {
+	keys, err := parseJsonPath(path)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var found bool
+			if doc, found = v[key]; !found {
+				return "", fmt.Errorf("no key %q", key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("no index %s in array of %d", key, len(v))
+			}
+			doc = v[i]
+		default:
+			return "", fmt.Errorf("cannot look up %q in %T", key, doc)
+		}
+	}
+	if s, ok := doc.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(doc)
+	return string(b), err
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest,
+// without returning an error, so it can be used in an io.MultiWriter.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
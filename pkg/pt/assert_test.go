@@ -0,0 +1,154 @@
+package pt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJsonPath(t *testing.T) {
+	tests := []struct {
+		path string
+		keys []string
+	}{
+		{"$", nil},
+		{"", nil},
+		{"$.data.items[0].id", []string{"data", "items", "0", "id"}},
+		{"data.items[0].id", []string{"data", "items", "0", "id"}},
+		{"$[2]", []string{"2"}},
+		{"$.m[1][0]", []string{"m", "1", "0"}},
+	}
+	for _, test := range tests {
+		if keys, err := parseJsonPath(test.path); err != nil || !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("parseJsonPath(%q) = %q, %v, want %q", test.path, keys, err, test.keys)
+		}
+	}
+	for _, path := range []string{"$.a[0", "$.a]0["} {
+		if _, err := parseJsonPath(path); err == nil {
+			t.Errorf("parseJsonPath(%q) succeeded", path)
+		}
+	}
+}
+
+func TestJsonPathValue(t *testing.T) {
+	var doc interface{}
+	dec := json.NewDecoder(strings.NewReader(`{"data": {"items": [{"id": "a1", "n": 1.50}, {"id": "b2", "ok": true}],
+		"none": null, "m": [[1, 2], [3]]}}`))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, want string
+		fails      bool
+	}{
+		{"$.data.items[0].id", "a1", false},
+		{"$.data.items[1].id", "b2", false},
+		{"$.data.items[0].n", "1.50", false}, // numbers as written
+		{"$.data.items[1].ok", "true", false},
+		{"$.data.none", "null", false},
+		{"$.data.m[1]", "[3]", false},
+		{"$.data.m[0][1]", "2", false},
+		{"$.data.missing", "", true},
+		{"$.data.items[2].id", "", true}, // out of range
+		{"$.data.items[-1]", "", true},
+		{"$.data.items.id", "", true}, // a key in an array
+		{"$.data.items[0].id.x", "", true},
+	}
+	for _, test := range tests {
+		got, err := JsonPathValue(doc, test.path)
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("JsonPathValue(%q) = %q, %v, want %q (error %v)", test.path, got, err, test.want, test.fails)
+		}
+	}
+}
+
+func TestAssertionsCheck(t *testing.T) {
+	body := []byte(`{"status": "up", "version": "1.2.3"}`)
+	header := http.Header{"Content-Type": {"application/json"}, "X-Cache": {"HIT"}}
+	tests := []struct {
+		a      Assertions
+		failed int
+	}{
+		{Assertions{}, 0},
+		{Assertions{Status: []int{200, 204}}, 0},
+		{Assertions{Status: []int{204, 301}}, 1},
+		{Assertions{BodyContains: `"up"`}, 0},
+		{Assertions{BodyContains: "down"}, 1},
+		{Assertions{BodyRegex: `"version": "1\.\d+`}, 0},
+		{Assertions{BodyRegex: `^up`}, 1},
+		{Assertions{Headers: map[string]string{"content-type": "^application/json", "x-cache": ""}}, 0},
+		{Assertions{Headers: map[string]string{"X-Cache": "MISS", "Age": ""}}, 2}, // no match, and missing
+		{Assertions{JsonPath: map[string]string{"$.status": "up", "version": "1.2.3"}}, 0},
+		{Assertions{JsonPath: map[string]string{"$.status": "down", "$.uptime": "1"}}, 2},
+		{Assertions{MinSize: 10, MaxSize: 100}, 0},
+		{Assertions{MinSize: 100}, 1},
+		{Assertions{MaxSize: 10}, 1},
+		{Assertions{Status: []int{500}, BodyContains: "down", MaxSize: 10}, 3},
+	}
+	pt := &PingTimes{RespCode: 200, Size: int64(len(body))}
+	for i, test := range tests {
+		if err := test.a.compile(); err != nil {
+			t.Fatalf("assertions %d: %v", i, err)
+		}
+		if failed := test.a.Check(pt, header, body); len(failed) != test.failed {
+			t.Errorf("assertions %d: failed %q, want %d failures", i, failed, test.failed)
+		}
+	}
+
+	if failed := (&Assertions{JsonPath: map[string]string{"$.a": "1"}}).Check(pt, header, []byte("<html>")); len(failed) != 1 ||
+		!strings.Contains(failed[0], "not JSON") {
+		t.Errorf("JsonPath of an HTML body failed %q, want not JSON", failed)
+	}
+	for _, a := range []Assertions{{BodyRegex: "("}, {Headers: map[string]string{"Age": "["}}, {JsonPath: map[string]string{"$.a[0": ""}}} {
+		if err := a.compile(); err == nil {
+			t.Errorf("%+v compiled", a)
+		}
+	}
+}
+
+func TestAssertionsCheckDNS(t *testing.T) {
+	info := &DNSInfo{Rcode: "Success", Answers: []string{"93.184.216.34", "www.example.com."}}
+	tests := []struct {
+		a      Assertions
+		expect []string
+		failed int
+	}{
+		{Assertions{}, nil, 0},
+		{Assertions{}, []string{"93.184.216.34", "WWW.Example.com"}, 0},
+		{Assertions{}, []string{"10.0.0.1"}, 1},
+		{Assertions{Rcode: "NameError"}, []string{"10.0.0.1"}, 2},
+	}
+	for i, test := range tests {
+		if failed := test.a.CheckDNS(info, test.expect); len(failed) != test.failed {
+			t.Errorf("case %d: failed %q, want %d failures", i, failed, test.failed)
+		}
+	}
+	if failed := (&Assertions{Rcode: "nameerror"}).CheckDNS(&DNSInfo{Rcode: "NameError"}, nil); len(failed) != 0 {
+		t.Errorf("rcode in another case failed %q", failed)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	// a body over MaxAssertBody is read to the end, but only the start is kept
+	body := append(bytes.Repeat([]byte("x"), MaxAssertBody), "the end"...)
+	buf := &limitedBuffer{max: MaxAssertBody}
+	var all bytes.Buffer
+	// in two reads, the first ending just under the limit
+	r := io.MultiReader(bytes.NewReader(body[:MaxAssertBody-3]), bytes.NewReader(body[MaxAssertBody-3:]))
+	n, err := io.Copy(io.MultiWriter(buf, &all), r)
+	if err != nil || n != int64(len(body)) || all.Len() != len(body) {
+		t.Fatalf("copied %d bytes (%v), want %d", n, err, len(body))
+	}
+	if buf.Len() != MaxAssertBody {
+		t.Errorf("kept %d bytes, want %d", buf.Len(), MaxAssertBody)
+	}
+	a := Assertions{BodyContains: "the end"}
+	if failed := a.Check(&PingTimes{RespCode: 200}, nil, buf.Bytes()); len(failed) != 1 {
+		t.Errorf("found the end of the body past MaxAssertBody")
+	}
+}
@@ -0,0 +1,90 @@
+package pt
+
+//  Capture of CDN and cache response headers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CDNHeaders are the response headers captured for the "cdn" preset of
+// Target.Headers: cache status, age, and which edge served the response.
+var CDNHeaders = []string{
+	"X-Cache", "X-Cache-Status", "Cache-Status", "CF-Cache-Status", "X-Cache-Hits",
+	"Age", "X-Served-By", "Via", "CF-Ray", "X-Amz-Cf-Pop",
+}
+
+// cacheStatusHeaders are the headers CacheStatus looks at, in order.
+var cacheStatusHeaders = []string{"CF-Cache-Status", "Cache-Status", "X-Cache-Status", "X-Cache"}
+
+// expandHeaders returns the header names to capture, with "cdn" replaced by
+// CDNHeaders and duplicates removed.
+func expandHeaders(names []string) []string {
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		list := []string{name}
+		if strings.EqualFold(name, "cdn") {
+			list = CDNHeaders
+		}
+		for _, n := range list {
+			n = http.CanonicalHeaderKey(n)
+			if !seen[n] {
+				seen[n] = true
+				expanded = append(expanded, n)
+			}
+		}
+	}
+	return expanded
+}
+
+// captureHeaders returns the values of the named headers present in h, with
+// multiple values joined by ", ".
+func captureHeaders(h http.Header, names []string) map[string]string {
+	var captured map[string]string
+	for _, name := range names {
+		if values, found := h[name]; found {
+			if captured == nil {
+				captured = make(map[string]string)
+			}
+			captured[name] = strings.Join(values, ", ")
+		}
+	}
+	return captured
+}
+
+// CacheStatus returns the cache status of a response from its CDN headers:
+// "HIT" or "MISS", another status as given (like "DYNAMIC" or "BYPASS"), or ""
+// if there is none.  When a header lists a status for each cache on the way,
+// like "MISS, HIT" from a shield and an edge, the last (nearest) one counts.
+func CacheStatus(h http.Header) string {
+	for _, name := range cacheStatusHeaders {
+		values := h[http.CanonicalHeaderKey(name)]
+		if len(values) == 0 {
+			continue
+		}
+		entries := strings.Split(values[len(values)-1], ",")
+		status := strings.ToUpper(strings.TrimSpace(entries[len(entries)-1]))
+		switch {
+		case strings.Contains(status, "HIT"): // like TCP_HIT, "Hit from cloudfront", "cdn; hit"
+			return "HIT"
+		case strings.Contains(status, "MISS") || strings.Contains(status, "FWD="):
+			return "MISS"
+		case len(status) > 0:
+			return strings.Fields(status)[0]
+		}
+	}
+	return ""
+}
+
+// NoCacheStatus is the cache group of results without a cache status.
+const NoCacheStatus = "NONE"
+
+// CacheGroup returns the cache status of a result to group it by, or
+// NoCacheStatus if the response had none.
+func CacheGroup(pt *PingTimes) string {
+	if len(pt.CacheStatus) == 0 {
+		return NoCacheStatus
+	}
+	return pt.CacheStatus
+}
@@ -0,0 +1,62 @@
+package pt
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExpandHeaders(t *testing.T) {
+	got := expandHeaders([]string{"x-request-id", "CDN", "age", "X-Request-Id"})
+	want := []string{"X-Request-Id"} // canonical, and Age once, in the preset
+	for _, name := range CDNHeaders {
+		want = append(want, http.CanonicalHeaderKey(name))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandHeaders = %q, want %q", got, want)
+	}
+	if got := expandHeaders(nil); got != nil {
+		t.Errorf("expandHeaders(nil) = %q", got)
+	}
+
+	h := http.Header{"Age": {"120"}, "Via": {"1.1 varnish", "1.1 edge"}, "Server": {"nginx"}}
+	captured := captureHeaders(h, expandHeaders([]string{"cdn"}))
+	if !reflect.DeepEqual(captured, map[string]string{"Age": "120", "Via": "1.1 varnish, 1.1 edge"}) {
+		t.Errorf("captured %q, want Age and Via", captured)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		status string
+	}{
+		{http.Header{}, ""},
+		{http.Header{"Age": {"300"}}, ""}, // an age alone is no status
+		{http.Header{"X-Cache": {"HIT"}}, "HIT"},
+		{http.Header{"X-Cache": {"Hit from cloudfront"}}, "HIT"},
+		{http.Header{"X-Cache": {"Miss from cloudfront"}}, "MISS"},
+		{http.Header{"X-Cache": {"TCP_HIT"}}, "HIT"},
+		{http.Header{"X-Cache": {"MISS, HIT"}}, "HIT"}, // the edge, after the shield
+		{http.Header{"X-Cache": {"HIT, MISS"}}, "MISS"},
+		{http.Header{"X-Cache-Status": {"EXPIRED"}}, "EXPIRED"},
+		{http.Header{"Cf-Cache-Status": {"DYNAMIC"}}, "DYNAMIC"},
+		{http.Header{"Cf-Cache-Status": {"hit"}, "X-Cache": {"MISS"}}, "HIT"}, // CF-Cache-Status first
+		{http.Header{"Cache-Status": {"ExampleCache; hit"}}, "HIT"},
+		{http.Header{"Cache-Status": {"Origin; hit, Edge; fwd=uri-miss"}}, "MISS"},
+		{http.Header{"Cache-Status": {"Edge; fwd=stale; fwd-status=304"}}, "MISS"},
+		{http.Header{"X-Cache": {""}}, ""},
+	}
+	for _, test := range tests {
+		if status := CacheStatus(test.header); status != test.status {
+			t.Errorf("CacheStatus(%v) = %q, want %q", test.header, status, test.status)
+		}
+	}
+
+	if group := CacheGroup(&PingTimes{}); group != NoCacheStatus {
+		t.Errorf("cache group of a result without a status is %q, want %q", group, NoCacheStatus)
+	}
+	if group := CacheGroup(&PingTimes{CacheStatus: "HIT"}); group != "HIT" {
+		t.Errorf("cache group of a hit is %q", group)
+	}
+}
@@ -0,0 +1,150 @@
+package pt
+
+//  Connection probe: times the DNS lookup, TCP connect and TLS handshake to a
+//  tcp:// or tls:// target, and returns PingTimes
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// defaultPorts are the ports of URL schemes with a default.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"tls":   "443",
+	"grpcs": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// connAddr returns the scheme, host and port of a tcp://, tls://, grpc:// or
+// grpcs:// target.  The port is required unless the scheme has a default.
+func connAddr(t *Target) (string, string, string, error) {
+	url := ParseURL(t.Url)
+	if url == nil {
+		return "", "", "", fmt.Errorf("cannot parse URL %s", t.Url)
+	}
+	host, port := url.Hostname(), url.Port()
+	if len(port) == 0 {
+		port = defaultPorts[url.Scheme]
+	}
+	if len(host) == 0 || len(port) == 0 {
+		return "", "", "", fmt.Errorf("%s is not %s://host:port", t.Url, url.Scheme)
+	}
+	return url.Scheme, host, port, nil
+}
+
+// newConnResult returns the PingTimes for a probe of the target that has not
+// connected yet: RespCode is 520 until the probe sets it.
+func newConnResult(t *Target, myLocation string) *PingTimes {
+	urlStr := t.Url
+	return &PingTimes{
+		Start:    time.Now(),
+		DestUrl:  &urlStr,
+		Location: &myLocation,
+		Remote:   "undefined",
+		RespCode: 520,
+		Family:   t.familyName(),
+	}
+}
+
+// connect looks up host, connects to it at port and, if useTLS, does the TLS
+// handshake offering the alpn protocols.  It records the time of each phase in
+// pt, with Total the time to connect, and the remote address, DNS answer and
+// TLS details.  The target's Resolver, Resolve entries, address family and TLS
+// settings apply as they do to HTTP.  The caller must close the connection.
+func (t *Target) connect(ctx context.Context, host, port string, useTLS bool, alpn []string, pt *PingTimes) (net.Conn, error) {
+	var err error
+	addr := t.dialAddr(net.JoinHostPort(host, port))
+	if dialHost, dialPort, _ := net.SplitHostPort(addr); net.ParseIP(dialHost) == nil {
+		var ips []net.IP
+		if t.dns != nil {
+			ips, pt.DNS, err = t.lookup(ctx, dialHost)
+		} else {
+			ips, err = net.DefaultResolver.LookupIP(ctx, t.network("ip"), dialHost)
+		}
+		pt.DnsLk = time.Since(pt.Start)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("lookup %s: %v", dialHost, err)
+		}
+		addr = net.JoinHostPort(ips[0].String(), dialPort)
+	}
+
+	tDnsLk := pt.Start.Add(pt.DnsLk)
+	pt.Remote = HostNoPort(addr)
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, t.network("tcp"), addr)
+	pt.TcpHs = time.Since(tDnsLk)
+	pt.Total = pt.TcpHs
+	if err != nil {
+		return nil, err
+	}
+	pt.newConns++
+	if !useTLS {
+		return t.withTCPInfo(conn, conn, pt), nil
+	}
+
+	cfg := t.tlsClientConfig()
+	cfg.NextProtos = alpn
+	if len(cfg.ServerName) == 0 && net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	tTlsSt := time.Now()
+	tconn := tls.Client(conn, cfg)
+	conn.SetDeadline(time.Now().Add(10 * time.Second)) // as for HTTPS
+	err = tconn.Handshake()
+	conn.SetDeadline(time.Time{})
+	pt.TlsHs = time.Since(tTlsSt)
+	pt.Total += pt.TlsHs
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS HS: %v", err)
+	}
+	cs := tconn.ConnectionState()
+	serverName := cfg.ServerName
+	if len(serverName) == 0 {
+		serverName = host
+	}
+	pt.TLS = NewTLSInfo(&cs, serverName, t.VerifyTLS, t.roots)
+	return t.withTCPInfo(tconn, conn, pt), nil
+}
+
+// withTCPInfo returns conn, or with Target.TcpInfo, conn wrapped to record the
+// TCP_INFO of raw, the TCP connection underneath, in pt when it is closed.
+func (t *Target) withTCPInfo(conn, raw net.Conn, pt *PingTimes) net.Conn {
+	if !t.TcpInfo {
+		return conn
+	}
+	return &tcpInfoConn{Conn: conn, raw: raw, pt: pt}
+}
+
+// FetchConn connects to a tcp:// or tls:// target, doing the TLS handshake for
+// tls://, and then closes the connection.  The PingTimes has the DNS lookup,
+// TCP and TLS handshake times, and Total is the time to connect (not including
+// DNS, as for HTTP).  RespCode is 200 if the connection succeeded, or 520 if
+// not.
+func FetchConn(t *Target, myLocation string) *PingTimes {
+	scheme, host, port, err := connAddr(t)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	ptResult := newConnResult(t, myLocation)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := t.connect(ctx, host, port, scheme == "tls", nil, ptResult)
+	if err != nil {
+		log.Printf("connect %s: %v", t.Url, err)
+		return ptResult
+	}
+	conn.Close()
+
+	ptResult.RespCode = 200
+	return ptResult
+}
@@ -0,0 +1,37 @@
+package pt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFetchConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	target := &Target{Url: "tcp://" + addr}
+	if err := target.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if pt := FetchConn(target, "test"); pt == nil || pt.Failed() || pt.TcpHs == 0 {
+		t.Errorf("connect to %s: %+v, want a connection", addr, pt)
+	}
+
+	// a server that is down is a failure, not a nil result
+	ln.Close()
+	if pt := FetchConn(target, "test"); pt == nil || !pt.Failed() {
+		t.Errorf("connect to %s after it closed: %+v, want a failed result", addr, pt)
+	}
+}
@@ -0,0 +1,128 @@
+package pt
+
+//  Cron expressions, for schedules and maintenance windows
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and day
+// of week, like "*/5 9-17 * * 1-5" (every five minutes in business hours), in
+// local time.  Each field is "*", a number, a range like "9-17", a list like
+// "1,15", or any of those with a step like "*/5".  Days of the week are 0
+// (Sunday) to 6, or 7 for Sunday too.  As in cron, when both the day of the
+// month and of the week are restricted, a day matching either one matches.
+// "@hourly", "@daily", "@weekly" and "@monthly" are shorthand.
+type Cron struct {
+	Expr                        string
+	minute, hour, dom, mon, dow uint64 // bit sets of matching values
+	domAny, dowAny              bool   // "*" day of month or of week
+}
+
+var cronShorthand = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCron parses a cron expression.
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if long, found := cronShorthand[strings.TrimSpace(expr)]; found {
+		fields = strings.Fields(long)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q does not have 5 fields: minute hour day month weekday", expr)
+	}
+	c := &Cron{Expr: expr, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.mon, &c.dow}
+	for i, field := range fields {
+		set, err := cronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %v", expr, err)
+		}
+		*sets[i] = set
+	}
+	if c.dow&(1<<7) != 0 { // 7 is Sunday too
+		c.dow |= 1
+	}
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron %q never matches", expr)
+	}
+	return c, nil
+}
+
+// cronField parses one field of a cron expression into a bit set of values.
+func cronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:slash]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value in %q", field)
+				}
+			} else if step > 1 {
+				hi = max // like 5/15: from 5 on
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is not within %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// dayMatches returns true if the day of t matches the expression.
+func (c *Cron) dayMatches(t time.Time) bool {
+	domOk := c.dom&(1<<uint(t.Day())) != 0
+	dowOk := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domOk && dowOk
+	}
+	return domOk || dowOk
+}
+
+// Next returns the first minute matching the expression after t, or the zero
+// time if there is none within five years (like "0 0 31 2 *").
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case c.mon&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
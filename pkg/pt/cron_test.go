@@ -0,0 +1,58 @@
+package pt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "*/5 9-17 * * 1-5", "0 2 * * 0", "0 0 1,15 * *", "5/20 * * * *", "0 0 * * 7", "@hourly", "@daily", "@weekly", "@monthly"} {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("ParseCron(%q): %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "0 0 31 2 *", "@yearly"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	// 2024-06-03 is a Monday
+	tests := []struct {
+		expr, from, next string
+	}{
+		{"* * * * *", "2024-06-03 10:00", "2024-06-03 10:01"},
+		{"*/15 * * * *", "2024-06-03 10:07", "2024-06-03 10:15"},
+		{"5/20 * * * *", "2024-06-03 10:26", "2024-06-03 10:45"},
+		{"*/5 9-17 * * 1-5", "2024-06-03 17:56", "2024-06-04 09:00"},
+		{"*/5 9-17 * * 1-5", "2024-06-07 17:55", "2024-06-10 09:00"}, // Friday to Monday
+		{"0 2 * * 0", "2024-06-03 10:00", "2024-06-09 02:00"},
+		{"0 2 * * 7", "2024-06-03 10:00", "2024-06-09 02:00"}, // 7 is Sunday too
+		{"@monthly", "2024-06-03 10:00", "2024-07-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		// both days restricted: either one matches
+		{"0 12 15 * 1", "2024-06-04 00:00", "2024-06-10 12:00"},
+		{"0 12 5 * 1", "2024-06-04 00:00", "2024-06-05 12:00"},
+		// seconds are ignored, and the minute itself is not next
+		{"30 10 * * *", "2024-06-03 10:30", "2024-06-04 10:30"},
+	}
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", test.expr, err)
+		}
+		from := at(test.from).Add(20 * time.Second)
+		if next := c.Next(from); !next.Equal(at(test.next)) {
+			t.Errorf("%q Next(%s) = %s, want %s", test.expr, test.from, next.Format("2006-01-02 15:04"), test.next)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package pt
+
+//  DNS queries to a chosen resolver: plain DNS over UDP or TCP, DNS over TLS
+//  (RFC 7858), or DNS over HTTPS (RFC 8484)
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DNSTimeout limits a DNS query made without a deadline in its context.
+const DNSTimeout = 5 * time.Second
+
+// DNSInfo describes the answer to a DNS query.
+type DNSInfo struct {
+	Resolver string   // resolver queried, as given in Target.Resolver
+	Name     string   // name queried
+	Type     string   // record type queried, like "A"
+	Rcode    string   // response code, like "Success" or "NameError"
+	Answers  []string // answer records: addresses, names, or other record data
+	TTL      uint32   // smallest TTL of the answers, in seconds
+}
+
+// IPs returns the addresses among the answers.
+func (d *DNSInfo) IPs() []net.IP {
+	var ips []net.IP
+	for _, a := range d.Answers {
+		if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Resolver is a DNS server to query.
+type Resolver struct {
+	Spec  string // as given to ParseResolver
+	proto string // udp, tcp, tls or https
+	addr  string // host:port, or URL for https
+}
+
+// ParseResolver parses a resolver spec: an address like "8.8.8.8" or
+// "udp://8.8.8.8:53" for plain DNS over UDP (retried over TCP if the answer is
+// truncated), "tcp://8.8.8.8" for DNS over TCP, "tls://1.1.1.1" for DNS over
+// TLS (port 853), or "https://dns.google/dns-query" for DNS over HTTPS.
+func ParseResolver(spec string) (*Resolver, error) {
+	r := &Resolver{Spec: spec, proto: "udp", addr: spec}
+	if i := strings.Index(spec, "://"); i >= 0 {
+		r.proto, r.addr = spec[:i], spec[i+3:]
+	}
+
+	port := "53"
+	switch r.proto {
+	case "udp", "tcp":
+	case "tls":
+		port = "853"
+	case "https":
+		r.addr = spec
+		return r, nil
+	default:
+		return nil, fmt.Errorf("resolver %q: unknown protocol %s", spec, r.proto)
+	}
+	if _, _, err := net.SplitHostPort(r.addr); err != nil {
+		r.addr = net.JoinHostPort(strings.Trim(r.addr, "[]"), port)
+	}
+	return r, nil
+}
+
+// host returns the host name or address of the resolver.
+func (r *Resolver) host() string {
+	if r.proto == "https" {
+		if u, err := url.Parse(r.addr); err == nil {
+			return u.Hostname()
+		}
+	}
+	return HostNoPort(r.addr)
+}
+
+// Query asks the resolver for records of type qtype for name, and returns the
+// answer.  A response with an error code (like NameError) is not an error.
+func (r *Resolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*DNSInfo, error) {
+	if _, found := ctx.Deadline(); !found {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DNSTimeout)
+		defer cancel()
+	}
+
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	if r.proto == "https" {
+		query.ID = 0 // recommended by RFC 8484 for HTTP caching
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	switch r.proto {
+	case "udp":
+		reply, err = r.exchangeUDP(ctx, packed)
+		if err == nil && len(reply) > 2 && reply[2]&0x02 != 0 { // TC: truncated
+			reply, err = r.exchangeStream(ctx, packed, false)
+		}
+	case "tcp":
+		reply, err = r.exchangeStream(ctx, packed, false)
+	case "tls":
+		reply, err = r.exchangeStream(ctx, packed, true)
+	case "https":
+		reply, err = r.exchangeHTTPS(ctx, packed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %v", r.Spec, err)
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(reply); err != nil {
+		return nil, fmt.Errorf("query %s: %v", r.Spec, err)
+	}
+	if resp.ID != query.ID {
+		return nil, fmt.Errorf("query %s: reply ID %d is not %d", r.Spec, resp.ID, query.ID)
+	}
+
+	info := &DNSInfo{
+		Resolver: r.Spec,
+		Name:     name,
+		Type:     strings.TrimPrefix(qtype.String(), "Type"),
+		Rcode:    strings.TrimPrefix(resp.RCode.String(), "RCode"),
+	}
+	for i, a := range resp.Answers {
+		if i == 0 || a.Header.TTL < info.TTL {
+			info.TTL = a.Header.TTL
+		}
+		info.Answers = append(info.Answers, resourceString(a.Body))
+	}
+	return info, nil
+}
+
+// resourceString formats the data of a resource record.
+func resourceString(body dnsmessage.ResourceBody) string {
+	switch rr := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(rr.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(rr.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return rr.CNAME.String()
+	case *dnsmessage.NSResource:
+		return rr.NS.String()
+	case *dnsmessage.PTRResource:
+		return rr.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", rr.Pref, rr.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", rr.Priority, rr.Weight, rr.Port, rr.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d", rr.NS, rr.MBox, rr.Serial)
+	case *dnsmessage.TXTResource:
+		return strings.Join(rr.TXT, "")
+	}
+	return fmt.Sprintf("%T", body)
+}
+
+func (r *Resolver) exchangeUDP(ctx context.Context, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, found := ctx.Deadline(); found {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 65535)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && bytes.Equal(reply[:2], query[:2]) { // skip stray replies
+			return reply[:n], nil
+		}
+	}
+}
+
+// exchangeStream sends the query over TCP, or TLS, with a two byte length
+// prefix on the query and reply.
+func (r *Resolver) exchangeStream(ctx context.Context, query []byte, useTLS bool) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, found := ctx.Deadline(); found {
+		conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(r.addr)
+		tconn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tconn.Handshake(); err != nil {
+			return nil, err
+		}
+		conn = tconn
+	}
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, reply)
+	return reply, err
+}
+
+func (r *Resolver) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, r.addr, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	// a new connection each time, so every query includes connection setup like
+	// the other protocols, closed when done rather than left idle
+	tr := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+}
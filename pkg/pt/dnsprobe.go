@@ -0,0 +1,123 @@
+package pt
+
+//  DNS probe: times a DNS query and returns PingTimes, for dns:// targets
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnsTypes maps the names of the record types a DNS probe may query to their types.
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// dnsQuery is the query a dns:// target makes.
+type dnsQuery struct {
+	name   string
+	qtype  dnsmessage.Type
+	server *Resolver
+	expect []string // answers expected, from Assert.Answers and expect parameters
+}
+
+// parseDNSQuery parses the URL of a dns:// target, like
+// "dns://www.google.com?type=AAAA&server=tls://1.1.1.1&expect=2607:f8b0::1".
+// The type defaults to A, and the server to the target's Resolver, or else the
+// first nameserver in /etc/resolv.conf.
+func parseDNSQuery(t *Target) (*dnsQuery, error) {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("no name to query in %s", t.Url)
+	}
+	params := u.Query()
+
+	q := &dnsQuery{name: u.Hostname(), qtype: dnsmessage.TypeA, server: t.dns}
+	if qtype := params.Get("type"); len(qtype) > 0 {
+		var found bool
+		if q.qtype, found = dnsTypes[strings.ToUpper(qtype)]; !found {
+			return nil, fmt.Errorf("unknown DNS record type %q", qtype)
+		}
+	}
+	if server := params.Get("server"); len(server) > 0 {
+		if q.server, err = ParseResolver(server); err != nil {
+			return nil, err
+		}
+	} else if q.server == nil {
+		if q.server, err = ParseResolver(systemNameserver()); err != nil {
+			return nil, err
+		}
+	}
+	q.expect = append(append(q.expect, t.Assert.Answers...), params["expect"]...)
+	return q, nil
+}
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf, or the
+// local host if there is none.
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return "127.0.0.1"
+}
+
+// FetchDNS makes the DNS query of a dns:// target and returns a PingTimes with
+// the query time as DnsLk (and Total), the resolver's address as Remote, and the
+// answer as DNS.  RespCode is 200 if the resolver answered, whatever its rcode,
+// or 520 if not.  An unexpected rcode (other than Assert.Rcode, or Success) or
+// missing expected answers are assertion failures.
+func FetchDNS(t *Target, myLocation string) *PingTimes {
+	q, err := parseDNSQuery(t)
+	if err != nil {
+		log.Printf("DNS probe %s: %v", t.Url, err)
+		return nil
+	}
+
+	urlStr := t.Url
+	ptResult := &PingTimes{
+		Start:    time.Now(),
+		DestUrl:  &urlStr,
+		Location: &myLocation,
+		Remote:   q.server.host(),
+		RespCode: 520,
+	}
+	info, err := q.server.Query(context.Background(), q.name, q.qtype)
+	ptResult.DnsLk = time.Since(ptResult.Start)
+	ptResult.Total = ptResult.DnsLk
+	if err != nil {
+		log.Printf("DNS probe %s: %v", t.Url, err)
+		return ptResult
+	}
+
+	ptResult.RespCode = 200
+	ptResult.DNS = info
+	ptResult.AssertFailures = t.Assert.CheckDNS(info, q.expect)
+	return ptResult
+}
@@ -0,0 +1,78 @@
+package pt
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers A queries for ok.test with 192.0.2.1, and others with
+// NameError, on a local UDP port it returns.
+func serveDNS(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			q := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+				Questions: query.Questions,
+			}
+			if q.Name.String() == "ok.test." {
+				reply.RCode = dnsmessage.RCodeSuccess
+				reply.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+				}}
+			}
+			if packed, err := reply.Pack(); err == nil {
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestFetchDNS(t *testing.T) {
+	server := serveDNS(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String() // a server that does not answer
+	ln.Close()
+
+	tests := []struct {
+		url    string
+		failed bool
+	}{
+		{"dns://ok.test?server=" + server, false},
+		{"dns://ok.test?server=" + server + "&expect=192.0.2.1", false},
+		{"dns://ok.test?server=" + server + "&expect=192.0.2.2", true},
+		{"dns://missing.test?server=" + server, true},
+		{"dns://ok.test?server=tcp://" + down, true},
+	}
+	for _, test := range tests {
+		target := &Target{Url: test.url}
+		if err := target.Init(); err != nil {
+			t.Fatal(err)
+		}
+		pt := FetchDNS(target, "test")
+		if pt == nil || pt.Failed() != test.failed {
+			t.Errorf("%s: %+v, want failed %v", test.url, pt, test.failed)
+		}
+	}
+}
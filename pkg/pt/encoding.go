@@ -0,0 +1,117 @@
+package pt
+
+//  Decoding of compressed response bodies, counting the bytes on the wire and
+//  the time spent decompressing
+
+import (
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Encodings are the content codings a target may accept (see Target.Encoding).
+var Encodings = []string{"gzip", "br", "zstd", "deflate", "identity"}
+
+// checkEncoding checks an Accept-Encoding list of content codings, like "gzip, br".
+func checkEncoding(accept string) error {
+	for _, coding := range strings.Split(accept, ",") {
+		coding = strings.TrimSpace(strings.SplitN(coding, ";", 2)[0]) // without ;q=
+		ok := coding == "*"
+		for _, e := range Encodings {
+			ok = ok || coding == e
+		}
+		if !ok {
+			return fmt.Errorf("Encoding %q is not one of %s", coding, strings.Join(Encodings, ", "))
+		}
+	}
+	return nil
+}
+
+// wireReader counts the bytes read from the response body as received, and
+// the time spent waiting for them.
+type wireReader struct {
+	r     io.Reader
+	bytes int64
+	wait  time.Duration
+}
+
+func (w *wireReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.r.Read(p)
+	w.wait += time.Since(start)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// bodyDecoder decodes a response body with the given content coding, keeping
+// track of the bytes on the wire and the time spent decoding them: the time
+// in its Read calls less the time waiting for the wire.
+type bodyDecoder struct {
+	wire     wireReader
+	encoding string
+	r        io.Reader     // decoded body, once started
+	total    time.Duration // in Read
+}
+
+func newBodyDecoder(body io.Reader, encoding string) *bodyDecoder {
+	return &bodyDecoder{wire: wireReader{r: body}, encoding: strings.ToLower(strings.TrimSpace(encoding))}
+}
+
+func (d *bodyDecoder) Read(p []byte) (int, error) {
+	start := time.Now()
+	defer func() { d.total += time.Since(start) }()
+
+	if d.r == nil { // start decoding on the first read, which may read a header
+		var r io.Reader
+		var err error
+		switch d.encoding {
+		case "", "identity":
+			r = &d.wire
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(&d.wire)
+		case "deflate":
+			r, err = zlib.NewReader(&d.wire)
+		case "br":
+			r = brotli.NewReader(&d.wire)
+		case "zstd":
+			var dec *zstd.Decoder
+			if dec, err = zstd.NewReader(&d.wire, zstd.WithDecoderConcurrency(1)); err == nil {
+				r = dec.IOReadCloser() // Close releases the decoder's resources
+			}
+		default:
+			err = fmt.Errorf("unsupported Content-Encoding %q", d.encoding)
+		}
+		if err != nil {
+			return 0, err
+		}
+		d.r = r
+	}
+	return d.r.Read(p)
+}
+
+// Close releases the decoder, if it needs to.
+func (d *bodyDecoder) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WireSize returns the bytes read from the wire.
+func (d *bodyDecoder) WireSize() int64 {
+	return d.wire.bytes
+}
+
+// DecodeTime returns the time spent decoding, not counting waiting for data.
+func (d *bodyDecoder) DecodeTime() time.Duration {
+	if d.r == io.Reader(&d.wire) {
+		return 0
+	}
+	return d.total - d.wire.wait
+}
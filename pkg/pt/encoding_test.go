@@ -0,0 +1,116 @@
+package pt
+
+import (
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// encode returns body compressed with the content coding.
+func encode(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		return body
+	}
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBodyDecoder(t *testing.T) {
+	body := bytes.Repeat([]byte("perftest measures the time of each phase of a request. "), 500)
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd", "identity", ""} {
+		wire := encode(t, encoding, body)
+		dec := newBodyDecoder(bytes.NewReader(wire), encoding)
+		decoded, err := ioutil.ReadAll(dec)
+		dec.Close()
+		if err != nil {
+			t.Errorf("%q: %v", encoding, err)
+			continue
+		}
+		if !bytes.Equal(decoded, body) {
+			t.Errorf("%q: decoded %d bytes that differ from the %d sent", encoding, len(decoded), len(body))
+		}
+		if dec.WireSize() != int64(len(wire)) {
+			t.Errorf("%q: wire size %d, want %d", encoding, dec.WireSize(), len(wire))
+		}
+		if compressed := len(encoding) > 0 && encoding != "identity"; compressed && len(wire) >= len(body)/10 {
+			t.Errorf("%q: %d bytes on the wire for a body of %d", encoding, len(wire), len(body))
+		} else if !compressed && dec.DecodeTime() != 0 {
+			t.Errorf("%q: decode time %s without decoding", encoding, dec.DecodeTime())
+		}
+	}
+}
+
+func TestFetchEncoded(t *testing.T) {
+	body := []byte(strings.Repeat("hello, world\n", 100))
+	gz := encode(t, "gzip", body)
+	corrupt := append([]byte{}, gz...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gz)
+		case "/corrupt":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(corrupt)
+		case "/unknown":
+			w.Header().Set("Content-Encoding", "compress")
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		failed bool
+	}{
+		{"/gzip", false},
+		{"/corrupt", true},
+		{"/unknown", true},
+	}
+	for _, test := range tests {
+		target := &Target{Url: server.URL + test.path, Encoding: "gzip"}
+		if err := target.Init(); err != nil {
+			t.Fatal(err)
+		}
+		pt := FetchTarget(target, "test")
+		if pt == nil {
+			t.Fatalf("%s: no result", test.path)
+		}
+		if pt.Failed() != test.failed {
+			t.Errorf("%s: failed %v (%q), want %v", test.path, pt.Failed(), pt.AssertFailures, test.failed)
+		}
+		if !test.failed && (pt.Size != int64(len(body)) || pt.WireSize != int64(len(gz)) || pt.ContentEncoding != "gzip") {
+			t.Errorf("%s: Size %d, WireSize %d, ContentEncoding %q, want %d, %d, gzip",
+				test.path, pt.Size, pt.WireSize, pt.ContentEncoding, len(body), len(gz))
+		}
+	}
+}
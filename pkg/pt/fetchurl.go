@@ -3,11 +3,20 @@ package pt
 //  HTTP fetcher returning PingTimes
 
 import (
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
@@ -56,26 +65,85 @@ func HostNoPort(addr string) string {
 // The caller should pass in a valid location string, for example "City,Country" where
 // the client is running.
 func FetchURL(rawurl string, myLocation string) *PingTimes {
+	return FetchTarget(&Target{Url: rawurl}, myLocation)
+}
+
+// FetchTarget is like FetchURL but applies the settings of the target, and checks
+// the response against the target's assertions.  A dns:// target is probed with
+// FetchDNS instead, a tcp:// or tls:// target with FetchConn, a grpc:// or
+// grpcs:// target with FetchGRPC, a ws:// or wss:// target with FetchWS, and a
+// target with Steps with FetchTransaction.
+func FetchTarget(t *Target, myLocation string) *PingTimes {
+	if len(t.Steps) > 0 {
+		return FetchTransaction(t, myLocation)
+	}
+
 	// Leveraged from https://github.com/reorx/httpstat
-	url := ParseURL(rawurl)
+	url := ParseURL(t.Url)
 	if url == nil {
-		log.Println("cannot parse URL", rawurl)
+		log.Println("cannot parse URL", t.Url)
 		return nil
 	}
+	switch url.Scheme {
+	case "dns":
+		return FetchDNS(t, myLocation)
+	case "tcp", "tls":
+		return FetchConn(t, myLocation)
+	case "grpc", "grpcs":
+		return FetchGRPC(t, myLocation)
+	case "ws", "wss":
+		return FetchWS(t, myLocation)
+	}
 
 	urlStr := url.Scheme + "://" + url.Host + url.Path
 
+	reqUrl := urlStr
 	httpMethod := http.MethodGet
+	var payload io.Reader
+	if t.UploadBytes > 0 {
+		httpMethod = http.MethodPost
+		payload = newPayloadReader(t.UploadBytes)
+	} else if t.step != nil {
+		// a step of a transaction, which may need its query, say how, and send a body
+		reqUrl = url.String()
+		if len(t.step.method) > 0 {
+			httpMethod = t.step.method
+		}
+		if len(t.step.body) > 0 {
+			payload = strings.NewReader(t.step.body)
+		}
+	}
 
-	req, err := http.NewRequest(httpMethod, urlStr, nil)
+	req, err := http.NewRequest(httpMethod, reqUrl, payload)
 	if err != nil {
 		log.Printf("create request: %v", err)
 		return nil
 	}
+	if t.step != nil {
+		for name, values := range t.step.header {
+			req.Header[name] = values
+		}
+	}
+	if t.UploadBytes > 0 {
+		req.ContentLength = t.UploadBytes
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if t.RangeBytes > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", t.RangeBytes-1))
+	}
+	if len(t.Encoding) > 0 {
+		// the transport then leaves the body as received, for bodyDecoder
+		req.Header.Set("Accept-Encoding", t.Encoding)
+	}
 
 	rmtAddr := "undefined"
+	var tlsInfo *TLSInfo
 
 	var tStart, tDnsLk, tTcpHs, tConnd, tFirst, tTlsSt, tTlsHs, tClose time.Time
+	var tWrote time.Time // request body sent, for uploads
+	var reused bool      // connection kept from a previous request, with a session
+	var newConns int     // connections made, if not reused
+	state := &dialState{}
 
 	tStart = time.Now()
 
@@ -89,10 +157,16 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 				// connecting to IP -- may be called multiple times (see httptrace.ClientTrace doc)
 				// so only kee the first timestamp
 				tDnsLk = tStart
+				if !state.tDnsLk.IsZero() { // looked up with the Resolver, by the dialer
+					tStart, tDnsLk = state.tStart, state.tDnsLk
+				}
 			}
 		},
 		ConnectDone: func(net, addr string, err error) {
 			tTcpHs = time.Now()
+			if t.Protocol == "h3" { // no TCP handshake: the QUIC one is timed as TLS
+				tTcpHs = tDnsLk
+			}
 			rmtAddr = HostNoPort(addr)
 			if err != nil {
 				log.Printf("connect %s: %v", addr, err)
@@ -104,31 +178,51 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 		// connecting to a HTTPS site via a HTTP proxy, the handshake happens after
 		// the CONNECT request is processed by the proxy.
 		TLSHandshakeStart: func() { tTlsSt = time.Now() }, // same as tTcpHs (roughly)???
-		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			tTlsHs = time.Now() // same as tConnd???
 			if err != nil {
 				log.Printf("TLS HS: %v", err)
+				return
 			}
-			tTlsHs = time.Now() // same as tConnd???
+			serverName := t.ServerName
+			if len(serverName) == 0 {
+				serverName = url.Hostname()
+			}
+			tlsInfo = NewTLSInfo(&cs, serverName, t.VerifyTLS, t.roots)
 		},
 
-		GotConn:              func(_ httptrace.GotConnInfo) { tConnd = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			tConnd = time.Now()
+			if reused = info.Reused; reused {
+				rmtAddr = HostNoPort(info.Conn.RemoteAddr().String())
+			} else {
+				newConns++
+			}
+		},
+		WroteRequest:         func(_ httptrace.WroteRequestInfo) { tWrote = time.Now() },
 		GotFirstResponseByte: func() { tFirst = time.Now() },
 	}
-	req = req.WithContext(httptrace.WithClientTrace(context.Background(), trace))
+	ctx := httptrace.WithClientTrace(context.WithValue(context.Background(), dialStateKey{}, state), trace)
+	req = req.WithContext(ctx)
 
-	tr := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // Warning: skips CA checks, but ping doesn't care
-		},
+	var rt http.RoundTripper
+	if sess := t.session; sess != nil { // keeping connections from one request to the next
+		sess.ctx = ctx
+		if sess.rt == nil {
+			sess.rt = t.roundTripper(func() context.Context { return sess.ctx })
+		}
+		rt = sess.rt
+	} else {
+		rt = t.roundTripper(func() context.Context { return ctx })
+		// a new transport for each request: do not leave its connection open
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			defer c.CloseIdleConnections()
+		}
 	}
 
 	client := &http.Client{
-		Transport: tr,
+		Transport: rt,
+		Jar:       t.stepJar(),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			// do not follow redirects; collect timing on the 301/302 instead
 			return http.ErrUseLastResponse
@@ -140,6 +234,24 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 	// so request start time is before the connection is attempted.
 	status := 520
 	var bytes int64
+	var writers []io.Writer // copies of the response body, if needed
+	var body *limitedBuffer // response body for assertions
+	if t.Assert.needsBody() || t.step != nil {
+		body = &limitedBuffer{max: MaxAssertBody}
+		writers = append(writers, body)
+	}
+	var sum hash.Hash // hashed as the body streams in, without keeping it
+	if t.hashBody() {
+		sum = sha256.New()
+		writers = append(writers, sum)
+	}
+	var curve *curveWriter // download throughput over time
+	if t.CurveMsec > 0 {
+		curve = &curveWriter{interval: time.Duration(t.CurveMsec) * time.Millisecond}
+		writers = append(writers, curve)
+	}
+	var dec *bodyDecoder // with an Encoding
+	var decodeErr error
 	resp, err := client.Do(req)
 	if resp != nil {
 		// Close body if non-nil, whatever err says (even if err non-nil)
@@ -149,11 +261,40 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 		log.Printf("reading response: %v", err)
 	} else {
 		// drain the response body, read all the bytes to set close time correctly
-		bytes = readResponseBody(req, resp)
+		var w io.Writer
+		if len(writers) > 0 {
+			w = io.MultiWriter(writers...)
+		}
+		var body io.Reader = resp.Body
+		if len(t.Encoding) > 0 {
+			dec = newBodyDecoder(resp.Body, resp.Header.Get("Content-Encoding"))
+			defer dec.Close()
+			body = dec
+		}
+		bytes, decodeErr = readResponseBody(req, body, w)
 		status = resp.StatusCode
 	}
-	tClose = time.Now() // after read body
+	tClose = time.Now()                            // after read body
+	if tDnsLk.IsZero() && !state.tDnsLk.IsZero() { // the Resolver lookup failed
+		tStart, tDnsLk = state.tStart, state.tDnsLk
+	}
+	dnsInfo := state.dnsInfo
+	tcpConn := state.conn
+	if sess := t.session; sess != nil {
+		if tcpConn != nil {
+			sess.conn = tcpConn
+		} else if reused {
+			tcpConn = sess.conn
+		}
+	}
+	var tcpInfo *TCPInfo
+	if t.TcpInfo && tcpConn != nil {
+		tcpInfo = readTCPInfo(tcpConn) // while the connection is still open, idle in the transport
+	}
 
+	if reused { // no lookup or handshakes
+		tDnsLk, tTcpHs = tStart, tStart
+	}
 	if tTcpHs.IsZero() { // DNS lookup failed or otherwise failed to connect
 		tTcpHs = tDnsLk
 		tFirst = tClose
@@ -161,9 +302,11 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 	} else if tConnd.IsZero() { // in case of read: connection reset by peer
 		tFirst = tClose
 		tConnd = tFirst
+	} else if tFirst.IsZero() { // connected but no reply, as when the server rejects a client cert
+		tFirst = tClose
 	}
 
-	return &PingTimes{
+	ptResult := &PingTimes{
 		Start:    tStart,             // request start
 		DnsLk:    tDnsLk.Sub(tStart), // DNS lookup
 		TcpHs:    tTcpHs.Sub(tDnsLk), // TCP connection handshake
@@ -176,21 +319,333 @@ func FetchURL(rawurl string, myLocation string) *PingTimes {
 		Remote:   rmtAddr,            // Server IP from DNS resolution
 		RespCode: status,
 		Size:     bytes,
+		TLS:      tlsInfo,
+		Family:   t.familyName(),
+		DNS:      dnsInfo,
+		TCPInfo:  tcpInfo,
+		Reused:   reused,
+		newConns: newConns,
 	}
+
+	if dec != nil {
+		ptResult.ContentEncoding = resp.Header.Get("Content-Encoding")
+		ptResult.WireSize = dec.WireSize()
+		ptResult.DecodeTime = dec.DecodeTime()
+		if decodeErr != nil {
+			ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("decoding body: %v", decodeErr))
+		}
+	}
+	if t.Download || curve != nil {
+		received := bytes
+		if dec != nil {
+			received = dec.WireSize()
+		}
+		ptResult.Throughput = BytesPerSec(received, ptResult.Close)
+	}
+	if curve != nil {
+		ptResult.ThroughputCurve = curve.Curve(tClose)
+	}
+	if t.UploadBytes > 0 && !tWrote.IsZero() && !tConnd.IsZero() {
+		ptResult.Upload = tWrote.Sub(tConnd)
+		ptResult.UploadThroughput = BytesPerSec(t.UploadBytes, ptResult.Upload)
+	}
+
+	if err == nil {
+		ptResult.Proto = resp.Proto
+		ptResult.Headers = captureHeaders(resp.Header, t.headers)
+		ptResult.ServerTiming = ParseServerTiming(resp.Header)
+		if len(t.headers) > 0 || t.GroupByCache {
+			ptResult.CacheStatus = CacheStatus(resp.Header)
+		}
+		if t.Protocol == "h2" && resp.ProtoMajor != 2 {
+			ptResult.AssertFailures = append(ptResult.AssertFailures, resp.Proto+" is not HTTP/2")
+		}
+		if sum != nil {
+			ptResult.BodySha256 = hex.EncodeToString(sum.Sum(nil))
+			ptResult.ETag = resp.Header.Get("ETag")
+			ptResult.LastModified = resp.Header.Get("Last-Modified")
+		}
+		var content []byte
+		if body != nil {
+			content = body.Bytes()
+		}
+		ptResult.AssertFailures = append(ptResult.AssertFailures, t.Assert.Check(ptResult, resp.Header, content)...)
+		if t.step != nil {
+			t.step.respHeader, t.step.respBody = resp.Header, content
+		}
+	}
+	return ptResult
+}
+
+// dialState is what the dialer records of the request it connects for, found
+// in the context of the request.
+type dialState struct {
+	tStart, tDnsLk time.Time // of a lookup with the Resolver
+	dnsInfo        *DNSInfo  // with the Resolver
+	conn           net.Conn  // last connection dialed, for TcpInfo
+}
+
+type dialStateKey struct{}
+
+// session keeps the connections to a target open from one request to the next
+// (see Target.WithSession), as a browser or a virtual user does.  Its requests
+// are made one at a time.
+type session struct {
+	rt   http.RoundTripper
+	ctx  context.Context // of the current request, for the h2c transport to dial with
+	conn net.Conn        // last connection dialed, for TcpInfo
 }
 
-// Consumes the body of the response ... simply discarding it at this point (be as fast as possible).
-func readResponseBody(req *http.Request, resp *http.Response) int64 {
+// WithSession returns a copy of the target that keeps its HTTP connections open
+// from one request to the next, for requests made one at a time.  Results on
+// a kept connection are Reused, with no DNS, TCP or TLS time.
+func (t *Target) WithSession() *Target {
+	s := *t
+	s.session = &session{}
+	return &s
+}
+
+// CloseSession closes the idle connections kept by a target from WithSession.
+func (t *Target) CloseSession() {
+	if t.session != nil && t.session.rt != nil {
+		if c, ok := t.session.rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// roundTripper returns a new transport for the target.  Connections are
+// dialed with the context of the request, or of dialCtx for h2c.  h3 is HTTP/3
+// over QUIC (see dialQUIC).
+func (t *Target) roundTripper(dialCtx func() context.Context) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		state, found := ctx.Value(dialStateKey{}).(*dialState)
+		if !found {
+			state = &dialState{}
+		}
+		addr = t.dialAddr(addr)
+		if host, port, err := net.SplitHostPort(addr); err == nil && t.dns != nil && net.ParseIP(host) == nil {
+			state.tStart = time.Now() // as in DNSStart
+			// a context without the trace, so the resolver's own connection is not timed as the request's
+			lctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
+			defer cancel()
+			ips, info, err := t.lookup(lctx, host)
+			state.tDnsLk = time.Now()
+			state.dnsInfo = info
+			if err != nil {
+				return nil, err
+			}
+			addr = net.JoinHostPort(ips[0].String(), port)
+		}
+		conn, err := dialer.DialContext(ctx, t.network(network), addr)
+		if err == nil {
+			state.conn = conn
+		}
+		return conn, err
+	}
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       t.tlsClientConfig(),
+	}
+
+	switch t.Protocol {
+	case "", "h2":
+		// offer h2 in ALPN, as the transport does not with a custom TLS config
+		if err := http2.ConfigureTransport(tr); err != nil {
+			log.Printf("configure HTTP/2: %v", err)
+		}
+	case "h1":
+		tr.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case "h2c":
+		// HTTP/2 with prior knowledge, without TLS
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(dialCtx(), network, addr)
+			},
+		}
+	case "h3":
+		return &http3.Transport{
+			TLSClientConfig: t.tlsClientConfig(),
+			Dial:            t.dialQUIC,
+		}
+	}
+	return tr
+}
+
+// dialQUIC connects to addr over QUIC for the h3 transport, recording the
+// lookup in the dialState of the request and reporting the connection to its
+// trace as httptrace does for TCP: the QUIC handshake, which includes TLS, as
+// the TLS handshake.  Each connection has its own UDP socket, of the address
+// family of the target, closed with the connection.
+func (t *Target) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	state, found := ctx.Value(dialStateKey{}).(*dialState)
+	if !found {
+		state = &dialState{}
+	}
+	host, port, err := net.SplitHostPort(t.dialAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		state.tStart = time.Now() // as in DNSStart
+		var ips []net.IP
+		if t.dns != nil {
+			// a context without the trace, so the resolver's own connection is not timed as the request's
+			lctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
+			defer cancel()
+			ips, state.dnsInfo, err = t.lookup(lctx, host)
+		} else {
+			ips, err = net.DefaultResolver.LookupIP(ctx, t.network("ip"), host)
+		}
+		state.tDnsLk = time.Now()
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+		for _, a := range ips { // IPv4 first, if either will do
+			if a.To4() != nil {
+				ip = a
+				break
+			}
+		}
+	}
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if ip.To4() == nil {
+		network = "udp6"
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+	if trace.ConnectStart != nil {
+		trace.ConnectStart(network, raddr.String())
+	}
+	udpConn, err := net.ListenUDP(network, nil)
+	if trace.ConnectDone != nil {
+		trace.ConnectDone(network, raddr.String(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := quic.Dial(ctx, udpConn, raddr, tlsConf, conf)
+	var cs tls.ConnectionState
+	if conn != nil {
+		cs = conn.ConnectionState().TLS
+	}
+	if trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(cs, err)
+	}
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		udpConn.Close()
+	}()
+	return conn, nil
+}
+
+// FetchEach resolves the host of the target and fetches the target from each of
+// its addresses in turn (of the target's address family, if limited to one),
+// returning the results in the order the addresses were resolved.  The DnsLk
+// of each result is the time of that one lookup.  If the URL is a DNS probe, or
+// its host is an IP address, or has a Resolve entry, or the lookup fails,
+// FetchEach returns the single result of FetchTarget.
+func FetchEach(t *Target, myLocation string) []*PingTimes {
+	url := ParseURL(t.Url)
+	if url == nil || url.Scheme == "dns" || len(t.Steps) > 0 {
+		return []*PingTimes{FetchTarget(t, myLocation)}
+	}
+	host, port := url.Hostname(), url.Port()
+	if len(port) == 0 {
+		port = defaultPorts[url.Scheme]
+	}
+	if _, found := t.resolve[net.JoinHostPort(host, port)]; found || net.ParseIP(host) != nil {
+		return []*PingTimes{FetchTarget(t, myLocation)}
+	}
+
+	var ips []net.IP
+	var dnsInfo *DNSInfo
+	var err error
+	start := time.Now()
+	if t.dns != nil {
+		ips, dnsInfo, err = t.lookupAll(context.Background(), host)
+	} else {
+		var addrs []net.IPAddr
+		addrs, err = net.DefaultResolver.LookupIPAddr(context.Background(), host)
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	lookup := time.Since(start)
+	if err != nil || len(ips) == 0 {
+		log.Printf("lookup %s: %v", host, err)
+		return []*PingTimes{FetchTarget(t, myLocation)} // records the failure
+	}
+
+	results := make([]*PingTimes, 0, len(ips))
+	for _, ip := range ips {
+		if isV4 := ip.To4() != nil; (t.Family == "4" && !isV4) || (t.Family == "6" && isV4) {
+			continue
+		}
+		pinned := *t
+		pinned.pin = net.JoinHostPort(ip.String(), port)
+		ptResult := FetchTarget(&pinned, myLocation)
+		if ptResult != nil {
+			ptResult.DnsLk = lookup
+			ptResult.DNS = dnsInfo
+		}
+		results = append(results, ptResult)
+	}
+	if len(results) == 0 { // none of the address family
+		return []*PingTimes{FetchTarget(t, myLocation)} // records the failure
+	}
+	return results
+}
+
+// FetchFamilies fetches the target over IPv4 and then over IPv6, returning the
+// two results in that order, so they can be compared.
+func FetchFamilies(t *Target, myLocation string) []*PingTimes {
+	v4, v6 := *t, *t
+	v4.Family, v6.Family = "4", "6"
+	return []*PingTimes{FetchTarget(&v4, myLocation), FetchTarget(&v6, myLocation)}
+}
+
+// Consumes the body of the response ... copying it to w if non-nil, otherwise simply
+// discarding it (be as fast as possible).
+func readResponseBody(req *http.Request, body io.Reader, w io.Writer) (int64, error) {
 	if req.Method == http.MethodHead {
-		return 0
+		return 0, nil
 	}
 
-	w := ioutil.Discard
-	bytes, err := io.Copy(w, resp.Body)
+	if w == nil {
+		w = ioutil.Discard
+	}
+	bytes, err := io.Copy(w, body)
 	if err != nil {
 		log.Printf("reading HTTP response body: %v", err)
 	}
-	return bytes
+	return bytes, err
 }
 
 // LocationFromEnv returns the current location from environment variables:
@@ -0,0 +1,44 @@
+package pt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchHashBody(t *testing.T) {
+	// a body larger than MaxAssertBody, with its end past the limit
+	body := append(bytes.Repeat([]byte("0123456789abcdef"), 3*MaxAssertBody/16), "the end"...)
+	sum := sha256.Sum256(body)
+	want := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	target := &Target{Url: server.URL, HashBody: true}
+	if err := target.Init(); err != nil {
+		t.Fatal(err)
+	}
+	pt := FetchTarget(target, "test")
+	if pt == nil || pt.Failed() {
+		t.Fatalf("fetch %s: %+v", server.URL, pt)
+	}
+	if pt.BodySha256 != want || pt.Size != int64(len(body)) || pt.ETag != `"v1"` {
+		t.Errorf("SHA-256 %s of %d bytes, ETag %s, want %s of %d bytes, ETag \"v1\"", pt.BodySha256, pt.Size, pt.ETag, want, len(body))
+	}
+
+	// the hash is of the whole body, though the body checked is cut short
+	target = &Target{Url: server.URL, Assert: Assertions{Sha256: want, BodyContains: "the end"}}
+	if err := target.Init(); err != nil {
+		t.Fatal(err)
+	}
+	pt = FetchTarget(target, "test")
+	if pt == nil || len(pt.AssertFailures) != 1 || pt.BodySha256 != want {
+		t.Errorf("fetch with assertions: %+v, want only the body beyond MaxAssertBody to fail", pt)
+	}
+}
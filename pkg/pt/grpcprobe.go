@@ -0,0 +1,183 @@
+package pt
+
+//  gRPC probe: a grpc.health.v1 Health/Check RPC returning PingTimes, for
+//  grpc:// (plaintext HTTP/2) and grpcs:// (over TLS) targets
+
+import (
+	"golang.org/x/net/http2"
+
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// grpcHealthPath is the method path of the gRPC health check.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcServingStatus names the values of HealthCheckResponse.ServingStatus.
+var grpcServingStatus = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// FetchGRPC connects to a grpc:// or grpcs:// target, sets up HTTP/2 (after the
+// TLS handshake, for grpcs://), and calls grpc.health.v1.Health/Check for the
+// service named by the URL path (like "grpc://host:50051/my.Service"; the
+// server as a whole if none).  The PingTimes has the DNS lookup, TCP and TLS
+// handshake times, Reply the time to the response headers and Close to the end
+// of the response, and the serving status as GrpcHealth.  RespCode is the HTTP
+// status, or 520 if the RPC was not made.  A gRPC error status, or a serving
+// status other than SERVING, is an assertion failure.
+func FetchGRPC(t *Target, myLocation string) *PingTimes {
+	scheme, host, port, err := connAddr(t)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	service := strings.Trim(ParseURL(t.Url).Path, "/")
+
+	ptResult := newConnResult(t, myLocation)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := t.connect(ctx, host, port, scheme == "grpcs", []string{"h2"}, ptResult)
+	if err != nil {
+		log.Printf("connect %s: %v", t.Url, err)
+		return ptResult
+	}
+	defer conn.Close()
+
+	tConnd := time.Now()
+	tr := &http2.Transport{AllowHTTP: true}
+	cc, err := tr.NewClientConn(conn)
+	if err != nil {
+		log.Printf("HTTP/2 %s: %v", t.Url, err)
+		return ptResult
+	}
+
+	// HealthCheckRequest { string service = 1; } in a gRPC message frame:
+	// uncompressed flag, length, then the protobuf encoding
+	msg := []byte{}
+	if len(service) > 0 {
+		msg = append(append([]byte{0x0a}, protoVarint(uint64(len(service)))...), service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	reqScheme := "http"
+	if scheme == "grpcs" {
+		reqScheme = "https"
+	}
+	req, err := http.NewRequest(http.MethodPost, reqScheme+"://"+net.JoinHostPort(host, port)+grpcHealthPath, bytes.NewReader(frame))
+	if err != nil {
+		log.Printf("create request: %v", err)
+		return ptResult
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := cc.RoundTrip(req)
+	tFirst := time.Now()
+	ptResult.Reply = tFirst.Sub(tConnd)
+	ptResult.Total += ptResult.Reply
+	if err != nil {
+		log.Printf("gRPC %s: %v", t.Url, err)
+		return ptResult
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxAssertBody))
+	ptResult.Close = time.Since(tFirst)
+	ptResult.Total += ptResult.Close
+	ptResult.RespCode = resp.StatusCode
+	ptResult.Size = int64(len(body))
+	if err != nil {
+		log.Printf("gRPC %s: reading response: %v", t.Url, err)
+		ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("reading response: %v", err))
+		return ptResult
+	}
+
+	// the status is in the trailers, or in the headers of a trailers-only response
+	status, message := resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	if len(status) == 0 {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	switch {
+	case status != "0":
+		ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("grpc-status %q: %s", status, message))
+	case len(body) < 5 || body[0] != 0:
+		ptResult.AssertFailures = append(ptResult.AssertFailures, "no uncompressed health check response")
+	default:
+		// HealthCheckResponse { ServingStatus status = 1; }, UNKNOWN if left out
+		serving := grpcServingStatus[0]
+		if value, found := protoVarintField(body[5:], 1); found {
+			serving = fmt.Sprint(value)
+			if value < uint64(len(grpcServingStatus)) {
+				serving = grpcServingStatus[value]
+			}
+		}
+		ptResult.GrpcHealth = serving
+		if serving != "SERVING" {
+			ptResult.AssertFailures = append(ptResult.AssertFailures, "health status "+serving)
+		}
+	}
+	ptResult.AssertFailures = append(ptResult.AssertFailures, t.Assert.Check(ptResult, resp.Header, nil)...)
+	return ptResult
+}
+
+// protoVarint encodes v as a protobuf varint.
+func protoVarint(v uint64) []byte {
+	var b []byte
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// protoVarintField returns the value of the varint field number in a protobuf
+// message, skipping other fields, and whether it was found.
+func protoVarintField(msg []byte, number uint64) (uint64, bool) {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, false
+		}
+		msg = msg[n:]
+		switch key & 7 { // wire type
+		case 0: // varint
+			value, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, false
+			}
+			if key>>3 == number {
+				return value, true
+			}
+			msg = msg[n:]
+		case 1: // 64 bit
+			if len(msg) < 8 {
+				return 0, false
+			}
+			msg = msg[8:]
+		case 2: // length delimited
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return 0, false
+			}
+			msg = msg[n+int(length):]
+		case 5: // 32 bit
+			if len(msg) < 4 {
+				return 0, false
+			}
+			msg = msg[4:]
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
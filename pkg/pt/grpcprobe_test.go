@@ -0,0 +1,88 @@
+package pt
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// grpcHealthServer answers health checks over h2c: SERVING for the server as a
+// whole, NOT_SERVING for the service "down", and NOT_FOUND for any other.
+func grpcHealthServer(t *testing.T) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthPath || r.Header.Get("Content-Type") != "application/grpc" {
+			http.Error(w, "not a health check", http.StatusBadRequest)
+			return
+		}
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(req) < 5 {
+			http.Error(w, "no request message", http.StatusBadRequest)
+			return
+		}
+		service := ""
+		if msg := req[5:]; len(msg) > 2 && msg[0] == 0x0a { // field 1, a string
+			service = string(msg[2 : 2+msg[1]])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		var status byte
+		switch service {
+		case "":
+			status = 1 // SERVING
+		case "down":
+			status = 2 // NOT_SERVING
+		default:
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		frame := make([]byte, 5, 7)
+		binary.BigEndian.PutUint32(frame[1:], 2)
+		w.Write(append(frame, 0x08, status)) // HealthCheckResponse { status }
+		w.Header().Set("Grpc-Status", "0")
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchGRPC(t *testing.T) {
+	server := grpcHealthServer(t)
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		service string
+		health  string
+		failed  bool
+	}{
+		{"", "SERVING", false},
+		{"down", "NOT_SERVING", true},
+		{"other", "", true},
+	}
+	for _, test := range tests {
+		target := &Target{Url: "grpc://" + addr + "/" + test.service}
+		if err := target.Init(); err != nil {
+			t.Fatalf("%s: Init: %v", target.Url, err)
+		}
+		p := FetchGRPC(target, "")
+		if p == nil {
+			t.Fatalf("%s: no result", target.Url)
+		}
+		if p.RespCode != http.StatusOK {
+			t.Errorf("%s: RespCode %d, want 200", target.Url, p.RespCode)
+		}
+		if p.GrpcHealth != test.health {
+			t.Errorf("%s: GrpcHealth %q, want %q", target.Url, p.GrpcHealth, test.health)
+		}
+		if p.Failed() != test.failed {
+			t.Errorf("%s: Failed() %v, want %v (assertion failures %q)", target.Url, p.Failed(), test.failed, p.AssertFailures)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package pt
+
+//  Histograms of durations, for distributions that averages hide
+
+import (
+	"time"
+)
+
+// HistogramBuckets are the upper bounds in milliseconds of the buckets of a
+// Histogram, after which there is one more bucket for anything slower.
+var HistogramBuckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Histogram counts durations in HistogramBuckets.
+type Histogram struct {
+	Counts []int64 // per bucket, not cumulative; the last is for over the last bound
+	Count  int64   // durations added
+	Sum    float64 // of the durations added, in milliseconds
+}
+
+// Add counts a duration.
+func (h *Histogram) Add(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HistogramBuckets)+1)
+	}
+	msec := Msec(d)
+	i := 0
+	for i < len(HistogramBuckets) && msec > HistogramBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += msec
+}
+
+// Mean returns the average duration added in milliseconds, or zero if none.
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// copy returns a copy of the histogram that does not share its counts.
+func (h *Histogram) copy() Histogram {
+	c := *h
+	c.Counts = append([]int64(nil), h.Counts...)
+	return c
+}
+
+// Merge adds the counts of another histogram to h.
+func (h *Histogram) Merge(o *Histogram) {
+	if len(o.Counts) == 0 {
+		return
+	}
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HistogramBuckets)+1)
+	}
+	for i, n := range o.Counts {
+		h.Counts[i] += n
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
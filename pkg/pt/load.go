@@ -0,0 +1,238 @@
+package pt
+
+//  Open-model load generation: requests at a scheduled rate, whatever the
+//  response time, with latency measured from when each request was due
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadStage is a stage of a load test (see Target.Load): requests at a rate
+// that goes from From to To requests per second over the Duration.
+type LoadStage struct {
+	From, To float64
+	Duration time.Duration
+}
+
+// ParseLoadStages parses a list of load stages like "50-500@5m,500@2m": a rate
+// in requests per second, or a range of rates to ramp through, for a duration.
+func ParseLoadStages(spec string) ([]LoadStage, error) {
+	var stages []LoadStage
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		at := strings.Index(part, "@")
+		if at < 0 {
+			return nil, fmt.Errorf("load stage %q is not rate@duration", part)
+		}
+		var s LoadStage
+		var err error
+		if s.Duration, err = time.ParseDuration(part[at+1:]); err != nil || s.Duration <= 0 {
+			return nil, fmt.Errorf("load stage %q: bad duration", part)
+		}
+		rates := strings.SplitN(part[:at], "-", 2)
+		if s.From, err = strconv.ParseFloat(rates[0], 64); err != nil || s.From < 0 {
+			return nil, fmt.Errorf("load stage %q: bad rate %q", part, rates[0])
+		}
+		s.To = s.From
+		if len(rates) == 2 {
+			if s.To, err = strconv.ParseFloat(rates[1], 64); err != nil || s.To < 0 {
+				return nil, fmt.Errorf("load stage %q: bad rate %q", part, rates[1])
+			}
+		}
+		stages = append(stages, s)
+	}
+	return stages, nil
+}
+
+// due returns when the nth request (from 0) of the stage is due, from the start
+// of the stage, or false if the stage ends first.  With the rate ramping
+// linearly, n requests are due by x seconds when From*x + k*x*x/2 = n.
+func (s LoadStage) due(n int) (time.Duration, bool) {
+	if n == 0 {
+		return 0, s.From > 0 || s.To > 0
+	}
+	k := (s.To - s.From) / s.Duration.Seconds() // ramp, in requests per second per second
+	var x float64
+	if k == 0 {
+		if s.From == 0 {
+			return 0, false
+		}
+		x = float64(n) / s.From
+	} else {
+		d := s.From*s.From + 2*k*float64(n)
+		if d < 0 { // ramping down, with no more requests due
+			return 0, false
+		}
+		x = (math.Sqrt(d) - s.From) / k
+	}
+	at := time.Duration(x * float64(time.Second))
+	return at, at < s.Duration
+}
+
+// StageReport is the result of a stage of a load test.  Latency is measured
+// from when each request was due to when it completed, so time spent waiting
+// for a free worker counts, and the percentiles are not skewed by a slow
+// server holding back the requests that would have seen it (coordinated
+// omission).
+type StageReport struct {
+	Url        string
+	Stage      int           // from 1
+	FromRate   float64       // requests per second due at the start
+	ToRate     float64       // and at the end
+	Duration   time.Duration // of the stage, or of the part run if stopped
+	Sent       int64         // requests made
+	Errors     int64         // failed requests, or those that returned no result
+	ErrorRate  float64       // Errors per request made
+	Throughput float64       // requests completed without error per second
+	Wait       float64       // average milliseconds a request waited for a free worker
+	Service    float64       // average response time in milliseconds, as in the results
+	P50        float64       // latency percentiles in milliseconds
+	P90        float64
+	P95        float64
+	P99        float64
+	Max        float64
+}
+
+// stageStats accumulates the results of a stage, from the workers.
+type stageStats struct {
+	sent, errors  int64
+	wait, service time.Duration
+	latency       []time.Duration
+}
+
+type loadRequest struct {
+	stage int
+	due   time.Time
+}
+
+// RunLoad tests the target at the rates of its Load stages, with at most
+// workers requests in flight, until the stages end or done is closed.  Each
+// worker keeps its connections open from one request to the next (see
+// WithSession).  Each result is passed to onResult, from the worker that
+// fetched it, and then a report of each stage started is returned.
+func RunLoad(t *Target, myLocation string, workers int, done <-chan int, onResult func(*PingTimes)) []StageReport {
+	if workers < 1 {
+		workers = 1
+	}
+	stats := make([]stageStats, len(t.stages))
+	elapsed := make([]time.Duration, len(t.stages))
+	var mu sync.Mutex // for stats
+
+	requests := make(chan loadRequest, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := t.WithSession()
+			defer worker.CloseSession()
+			for req := range requests {
+				started := time.Now()
+				ptResult := FetchTarget(worker, myLocation)
+				latency := time.Since(req.due)
+				onResult(ptResult)
+
+				mu.Lock()
+				s := &stats[req.stage]
+				s.sent++
+				s.wait += started.Sub(req.due)
+				s.latency = append(s.latency, latency)
+				if ptResult == nil || ptResult.Failed() {
+					s.errors++
+				}
+				if ptResult != nil {
+					s.service += ptResult.RespTime()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	stopped := false
+	for i := 0; i < len(t.stages) && !stopped; i++ {
+		stage := t.stages[i]
+		for n := 0; !stopped; n++ {
+			at, ok := stage.due(n)
+			if !ok {
+				break
+			}
+			due := start.Add(at)
+			select {
+			case <-done:
+				stopped = true
+				continue
+			case <-time.After(time.Until(due)):
+			}
+			// blocks while all workers are busy, so later requests are behind schedule
+			select {
+			case <-done:
+				stopped = true
+			case requests <- loadRequest{stage: i, due: due}:
+			}
+		}
+		elapsed[i] = stage.Duration
+		if stopped {
+			elapsed[i] = time.Since(start)
+		} else { // wait out the stage after its last request
+			select {
+			case <-done:
+				stopped = true
+				elapsed[i] = time.Since(start)
+			case <-time.After(time.Until(start.Add(stage.Duration))):
+			}
+		}
+		start = start.Add(stage.Duration)
+	}
+	close(requests)
+	wg.Wait()
+
+	var reports []StageReport
+	for i, stage := range t.stages {
+		if elapsed[i] <= 0 {
+			break // not started
+		}
+		s := &stats[i]
+		r := StageReport{
+			Url:        t.Url,
+			Stage:      i + 1,
+			FromRate:   stage.From,
+			ToRate:     stage.To,
+			Duration:   elapsed[i],
+			Sent:       s.sent,
+			Errors:     s.errors,
+			Throughput: float64(s.sent-s.errors) / elapsed[i].Seconds(),
+		}
+		if s.sent > 0 {
+			r.ErrorRate = float64(s.errors) / float64(s.sent)
+			r.Wait = Msec(s.wait) / float64(s.sent)
+			r.Service = Msec(s.service) / float64(s.sent)
+			sort.Slice(s.latency, func(a, b int) bool { return s.latency[a] < s.latency[b] })
+			r.P50 = Msec(percentile(s.latency, 50))
+			r.P90 = Msec(percentile(s.latency, 90))
+			r.P95 = Msec(percentile(s.latency, 95))
+			r.P99 = Msec(percentile(s.latency, 99))
+			r.Max = Msec(s.latency[len(s.latency)-1])
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// percentile returns the pth percentile of sorted durations, by nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
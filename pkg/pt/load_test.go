@@ -0,0 +1,80 @@
+package pt
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLoadStages(t *testing.T) {
+	stages, err := ParseLoadStages("50-500@5m, 500@2m,0.5@10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LoadStage{
+		{From: 50, To: 500, Duration: 5 * time.Minute},
+		{From: 500, To: 500, Duration: 2 * time.Minute},
+		{From: 0.5, To: 0.5, Duration: 10 * time.Second},
+	}
+	if len(stages) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(stages), len(want))
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Errorf("stage %d is %+v, want %+v", i+1, stages[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{"", "50", "50@", "@1m", "50@0s", "50@-1m", "x@1m", "-5@1m", "5-x@1m", "5-@1m"} {
+		if _, err := ParseLoadStages(spec); err == nil {
+			t.Errorf("ParseLoadStages(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// count returns the number of requests due in the stage.
+func count(s LoadStage) int {
+	n := 0
+	for {
+		if _, ok := s.due(n); !ok {
+			return n
+		}
+		n++
+	}
+}
+
+func TestLoadStageDue(t *testing.T) {
+	steady := LoadStage{From: 10, To: 10, Duration: 2 * time.Second}
+	for n, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if at, ok := steady.due(n); !ok || at != want {
+			t.Errorf("steady due(%d) = %s, %v, want %s, true", n, at, ok, want)
+		}
+	}
+	tests := []struct {
+		stage LoadStage
+		count int
+	}{
+		{steady, 20},
+		{LoadStage{From: 0, To: 10, Duration: 10 * time.Second}, 50},   // a ramp up from nothing
+		{LoadStage{From: 10, To: 0, Duration: 10 * time.Second}, 50},   // and down
+		{LoadStage{From: 50, To: 150, Duration: time.Minute}, 6000},    // (50 + 150) / 2 per second
+		{LoadStage{From: 0, To: 0, Duration: time.Minute}, 0},          // a pause
+		{LoadStage{From: 0.5, To: 0.5, Duration: 10 * time.Second}, 5}, // slower than one a second
+	}
+	for _, test := range tests {
+		if got := count(test.stage); math.Abs(float64(got-test.count)) > 1 {
+			t.Errorf("%+v: %d requests due, want %d", test.stage, got, test.count)
+		}
+	}
+
+	// requests come closer together as the rate ramps up
+	ramp := LoadStage{From: 1, To: 100, Duration: 10 * time.Second}
+	var last, gap time.Duration = 0, time.Hour
+	for n := 1; n < count(ramp); n++ {
+		at, _ := ramp.due(n)
+		if at-last > gap {
+			t.Fatalf("request %d is %s after the last, more than the %s before", n, at-last, gap)
+		}
+		last, gap = at, at-last
+	}
+}
@@ -0,0 +1,195 @@
+package pt
+
+//  Maintenance windows, that pause the tests of targets or silence their alerts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Window is a planned maintenance window of some targets: one-off, from Start
+// to End, or recurring, for Minutes from each minute of a Cron expression.
+// While a window is active its targets are not tested, with the Action
+// "pause", or are tested without sending alerts, with "silence".
+type Window struct {
+	ID      int        // assigned when added (see Maintenance.Add)
+	Name    string     // for people, like "database upgrade"
+	Targets []string   `json:",omitempty"` // URLs of the targets, where * matches anything (default all)
+	Action  string     // "pause" or "silence" (default)
+	Start   *time.Time `json:",omitempty"` // of a one-off window, like "2024-06-01T02:00:00Z"
+	End     *time.Time `json:",omitempty"` // of a one-off window
+	Cron    string     `json:",omitempty"` // start of each recurring window, like "0 2 * * 0" (see Cron)
+	Minutes int        `json:",omitempty"` // length of each recurring window
+
+	cron *Cron // from Cron
+}
+
+// WindowStatus is a maintenance window and whether it is active.
+type WindowStatus struct {
+	Window
+	Active bool
+}
+
+// init checks the settings of the window and compiles its Cron.
+func (w *Window) init() error {
+	switch w.Action {
+	case "":
+		w.Action = "silence"
+	case "pause", "silence":
+	default:
+		return fmt.Errorf("Action %q is not pause or silence", w.Action)
+	}
+	if len(w.Cron) > 0 {
+		if w.Start != nil || w.End != nil {
+			return fmt.Errorf("a window is one-off, from Start to End, or recurring, with Cron; not both")
+		}
+		if w.Minutes < 1 {
+			return fmt.Errorf("Minutes %d of a recurring window is not positive", w.Minutes)
+		}
+		var err error
+		w.cron, err = ParseCron(w.Cron)
+		return err
+	}
+	if w.Start == nil || w.End == nil {
+		return fmt.Errorf("a window needs a Start and End, or a Cron and Minutes")
+	}
+	if !w.End.After(*w.Start) {
+		return fmt.Errorf("End %s is not after Start %s", w.End.Format(time.RFC3339), w.Start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Active returns true if the window is active at now.
+func (w *Window) Active(now time.Time) bool {
+	if w.cron == nil {
+		return !now.Before(*w.Start) && now.Before(*w.End)
+	}
+	// the first start that could still be active at now
+	length := time.Duration(w.Minutes) * time.Minute
+	start := w.cron.Next(now.Add(-length))
+	return !start.IsZero() && !start.After(now)
+}
+
+// ended returns true if a one-off window is over at now.
+func (w *Window) ended(now time.Time) bool {
+	return w.cron == nil && !now.Before(*w.End)
+}
+
+// Matches returns true if the window applies to the target with the URL.
+func (w *Window) Matches(url string) bool {
+	if len(w.Targets) == 0 {
+		return true
+	}
+	for _, pattern := range w.Targets {
+		if globMatch(pattern, url) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch returns true if s matches the pattern, where * matches any string.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// Maintenance is a set of maintenance windows, safe for concurrent use.  A nil
+// *Maintenance has no windows.
+type Maintenance struct {
+	mu      sync.Mutex
+	windows []*Window
+	lastID  int
+}
+
+// NewMaintenance returns an empty set of maintenance windows.
+func NewMaintenance() *Maintenance {
+	return &Maintenance{}
+}
+
+// Add checks a window and adds it to the set, returning it with its ID.  One-off
+// windows that have ended are dropped.
+func (m *Maintenance) Add(w Window) (Window, error) {
+	if err := w.init(); err != nil {
+		return w, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	w.ID = m.lastID
+	now := time.Now()
+	kept := m.windows[:0]
+	for _, old := range m.windows {
+		if !old.ended(now) {
+			kept = append(kept, old)
+		}
+	}
+	m.windows = append(kept, &w)
+	return w, nil
+}
+
+// Remove removes the window with the ID from the set, returning false if there
+// is none.
+func (m *Maintenance) Remove(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.windows {
+		if w.ID == id {
+			m.windows = append(m.windows[:i], m.windows[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the windows of the set that have not ended, by ID, and whether
+// each is active at now.
+func (m *Maintenance) List(now time.Time) []WindowStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]WindowStatus, 0, len(m.windows))
+	for _, w := range m.windows {
+		if !w.ended(now) {
+			list = append(list, WindowStatus{Window: *w, Active: w.Active(now)})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Check returns whether the tests of the target with the URL are paused at now,
+// and whether its alerts are silenced, by active windows.  Pausing the tests
+// silences the alerts too.
+func (m *Maintenance) Check(url string, now time.Time) (paused, silenced bool) {
+	if m == nil {
+		return false, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.windows {
+		if w.Matches(url) && w.Active(now) {
+			silenced = true
+			if w.Action == "pause" {
+				paused = true
+			}
+		}
+	}
+	return paused, silenced
+}
@@ -23,6 +23,40 @@ type PingTimes struct {
 	Remote   string        // Server IP from DNS resolution
 	RespCode int           // HTTP response code or -1 (for network failure)
 	Size     int64         // total response bytes
+
+	Anomalies      []Anomaly       `json:",omitempty"` // time components slower than baseline
+	AssertFailures []string        `json:",omitempty"` // response content assertions that failed
+	BodySha256     string          `json:",omitempty"` // SHA-256 of the body in hex, if hashed
+	ETag           string          `json:",omitempty"` // ETag response header, if hashed
+	LastModified   string          `json:",omitempty"` // Last-Modified response header, if hashed
+	TLS            *TLSInfo        `json:",omitempty"` // TLS connection details, for HTTPS
+	Family         string          `json:",omitempty"` // IPv4 or IPv6, if the target is limited to one
+	DNS            *DNSInfo        `json:",omitempty"` // DNS answer, with a Resolver
+	GrpcHealth     string          `json:",omitempty"` // serving status from a gRPC health check
+	EchoRTT        []time.Duration `json:",omitempty"` // WebSocket message round trip times
+	Proto          string          `json:",omitempty"` // HTTP protocol of the response, like "HTTP/2.0"
+
+	Throughput       float64       `json:",omitempty"` // download bytes per second: Size over the LastB time
+	ThroughputCurve  []float64     `json:",omitempty"` // download bytes per second in each interval
+	Upload           time.Duration `json:",omitempty"` // time to send the request body (included in Reply)
+	UploadThroughput float64       `json:",omitempty"` // upload bytes per second
+
+	ContentEncoding string        `json:",omitempty"` // Content-Encoding of the response, with an Encoding
+	WireSize        int64         `json:",omitempty"` // response body bytes received, before decoding into Size
+	DecodeTime      time.Duration `json:",omitempty"` // time spent decoding the body
+
+	Headers     map[string]string `json:",omitempty"` // response headers captured (see Target.Headers)
+	CacheStatus string            `json:",omitempty"` // cache status like HIT or MISS, with captured headers
+
+	ServerTiming []ServerTiming `json:",omitempty"` // metrics from the Server-Timing response header
+	TCPInfo      *TCPInfo       `json:",omitempty"` // kernel TCP statistics, with Target.TcpInfo
+
+	Reused bool `json:",omitempty"` // connection kept open from a previous request, with no handshakes
+
+	Step  string       `json:",omitempty"` // name of the transaction step, for the result of a step
+	Steps []*PingTimes `json:",omitempty"` // results of the steps run, for a transaction
+
+	newConns int // connections made for the request, not kept from a previous one
 }
 
 // RespTime returns the total duration from the TCP open until the TCP close.
@@ -36,6 +70,15 @@ func (pt *PingTimes) RespTime() time.Duration {
 	return pt.Total
 }
 
+// Failed reports whether the request failed: FetchURL could not complete it
+// (RespCode 520, or -1 from other sources), the server replied with an HTTP
+// error status (4xx or 5xx), the response failed an assertion, or the server
+// certificate failed verification (in strict mode).
+func (pt *PingTimes) Failed() bool {
+	return pt.RespCode < 100 || pt.RespCode >= 400 || len(pt.AssertFailures) > 0 ||
+		(pt.TLS != nil && len(pt.TLS.VerifyError) > 0)
+}
+
 // Msec converts a time.Duration to a floating point number of seconds.
 func Msec(d time.Duration) float64 {
 	sec := d / time.Second
@@ -0,0 +1,74 @@
+package pt
+
+//  Fixed-rate and cron scheduling of the probes of a target
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Schedule times the probes of a target at a fixed rate: at start plus the
+// offset plus each multiple of the interval, so the period does not drift by
+// the time each probe takes.  Each tick is delayed by a random jitter up to
+// Jitter, so probes from many instances do not all arrive at once.  With a
+// Cron expression, the ticks are at its minutes instead.
+type Schedule struct {
+	Interval time.Duration // between ticks; zero for one probe right after another
+	Jitter   time.Duration // most random delay of each tick
+	Offset   time.Duration // of the first tick from the start
+	Cron     *Cron         // times the ticks instead of Interval and Offset, if not nil
+
+	start time.Time
+	n     int64 // ticks so far
+	rnd   *rand.Rand
+}
+
+// NewSchedule returns a schedule of ticks starting at start.
+func NewSchedule(start time.Time, interval, jitter, offset time.Duration) *Schedule {
+	return &Schedule{
+		Interval: interval,
+		Jitter:   jitter,
+		Offset:   offset,
+		start:    start,
+		rnd:      rand.New(rand.NewSource(start.UnixNano())),
+	}
+}
+
+// Schedule returns the schedule of the tests of the target from start: at its
+// Cron minutes if it has one, or else every IntervalMsec after OffsetMsec.
+func (t *Target) Schedule(start time.Time) *Schedule {
+	msec := func(n int64) time.Duration { return time.Duration(n) * time.Millisecond }
+	s := NewSchedule(start, msec(t.IntervalMsec), msec(t.JitterMsec), msec(t.OffsetMsec))
+	s.Cron = t.cron
+	return s
+}
+
+// Next returns the time of the next tick, with jitter, and the number of ticks
+// skipped.  A tick already past at now is due at once, but one a whole
+// interval or more past is skipped, as when a probe took longer than the
+// interval, rather than made up in a burst.  A cron tick is the next minute
+// of the expression after now, so none is ever past.
+func (s *Schedule) Next(now time.Time) (time.Time, int64) {
+	if s.Cron != nil {
+		tick := s.Cron.Next(now)
+		if s.Jitter > 0 {
+			tick = tick.Add(time.Duration(s.rnd.Int63n(int64(s.Jitter))))
+		}
+		return tick, 0
+	}
+	if s.Interval <= 0 {
+		s.n++
+		return now, 0
+	}
+	tick := s.start.Add(s.Offset + time.Duration(s.n)*s.Interval)
+	var skipped int64
+	if late := now.Sub(tick); late >= s.Interval {
+		skipped = int64(late / s.Interval)
+		tick = tick.Add(time.Duration(skipped) * s.Interval)
+	}
+	s.n += skipped + 1
+	if s.Jitter > 0 {
+		tick = tick.Add(time.Duration(s.rnd.Int63n(int64(s.Jitter))))
+	}
+	return tick, skipped
+}
@@ -0,0 +1,76 @@
+package pt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	sec := func(n float64) time.Time { return start.Add(time.Duration(n * float64(time.Second))) }
+
+	s := NewSchedule(start, 10*time.Second, 0, 2*time.Second)
+	tests := []struct {
+		now     time.Time
+		tick    time.Time
+		skipped int64
+	}{
+		{sec(0), sec(2), 0},   // the first after the offset
+		{sec(3), sec(12), 0},  // on the interval, not after the last probe
+		{sec(14), sec(22), 0}, // though the probe ran late
+		{sec(25), sec(32), 0},
+		{sec(35), sec(42), 0},
+		{sec(45.5), sec(52), 0},
+		{sec(55), sec(62), 0},
+		{sec(80), sec(72), 0},   // past, but less than an interval: due at once
+		{sec(115), sec(112), 3}, // more than an interval past: 82, 92 and 102 are skipped
+		{sec(113), sec(122), 0},
+	}
+	for i, test := range tests {
+		tick, skipped := s.Next(test.now)
+		if !tick.Equal(test.tick) || skipped != test.skipped {
+			t.Errorf("tick %d: Next(%s) = %s, %d skipped, want %s, %d", i, test.now.Sub(start),
+				tick.Sub(start), skipped, test.tick.Sub(start), test.skipped)
+		}
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	s := NewSchedule(start, 10*time.Second, 3*time.Second, 0)
+	for i := 0; i < 100; i++ {
+		base := start.Add(time.Duration(i) * 10 * time.Second)
+		tick, _ := s.Next(base)
+		if tick.Before(base) || !tick.Before(base.Add(3*time.Second)) {
+			t.Fatalf("tick %d is %s after its time, not within the jitter of 3s", i, tick.Sub(base))
+		}
+	}
+}
+
+func TestScheduleNoInterval(t *testing.T) {
+	s := NewSchedule(time.Now(), 0, 0, 0)
+	now := time.Now().Add(time.Hour)
+	if tick, skipped := s.Next(now); !tick.Equal(now) || skipped != 0 {
+		t.Errorf("Next(now) = %s, %d skipped, want now, 0", tick, skipped)
+	}
+}
+
+func TestScheduleCron(t *testing.T) {
+	c, err := ParseCron("*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 6, 3, 10, 1, 30, 0, time.Local)
+	target := &Target{Cron: "*/5 * * * *", IntervalMsec: 1000}
+	target.cron = c
+	s := target.Schedule(start)
+	want := time.Date(2024, 6, 3, 10, 5, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		tick, skipped := s.Next(start)
+		if !tick.Equal(want) || skipped != 0 {
+			t.Errorf("Next = %s, %d skipped, want %s, 0", tick, skipped, want)
+		}
+		start = tick.Add(time.Second) // a probe of a second
+		want = want.Add(5 * time.Minute)
+	}
+}
@@ -0,0 +1,87 @@
+package pt
+
+//  W3C Server-Timing response header parsing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerTiming is one metric from a Server-Timing response header, like
+// "db;dur=12.5;desc=\"Database\"".
+type ServerTiming struct {
+	Name string
+	Dur  time.Duration // zero if the metric has no duration
+	Desc string        `json:",omitempty"`
+}
+
+// ParseServerTiming returns the metrics in the Server-Timing headers of h, in
+// order.  Of metrics with the same name, only the first is kept.
+func ParseServerTiming(h http.Header) []ServerTiming {
+	var metrics []ServerTiming
+	seen := make(map[string]bool)
+	for _, value := range h["Server-Timing"] {
+		for _, entry := range splitQuoted(value, ',') {
+			params := splitQuoted(entry, ';')
+			m := ServerTiming{Name: strings.TrimSpace(params[0])}
+			if len(m.Name) == 0 || seen[m.Name] {
+				continue
+			}
+			for _, param := range params[1:] {
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+				switch strings.ToLower(strings.TrimSpace(kv[0])) {
+				case "dur":
+					if msec, err := strconv.ParseFloat(val, 64); err == nil {
+						m.Dur = time.Duration(msec * float64(time.Millisecond))
+					}
+				case "desc":
+					m.Desc = val
+				}
+			}
+			seen[m.Name] = true
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// splitQuoted splits s at each sep outside of double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++ // skip the escaped character
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ServerTimingTsv returns the server timing metrics with a duration as one
+// column of name=msec pairs, like "db=12.500;app=40.000", or "-" if there are
+// none, to follow MsecTsv in a servertiming column.
+func (pt *PingTimes) ServerTimingTsv() string {
+	var cols []string
+	for _, m := range pt.ServerTiming {
+		if m.Dur > 0 {
+			cols = append(cols, fmt.Sprintf("%s=%.03f", m.Name, Msec(m.Dur)))
+		}
+	}
+	if len(cols) == 0 {
+		return "-"
+	}
+	return strings.Join(cols, ";")
+}
@@ -0,0 +1,33 @@
+package pt
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseServerTiming(t *testing.T) {
+	ms := func(n float64) time.Duration { return time.Duration(n * float64(time.Millisecond)) }
+	tests := []struct {
+		headers []string
+		want    []ServerTiming
+	}{
+		{nil, nil},
+		{[]string{"db;dur=12, app;dur=40"}, []ServerTiming{{Name: "db", Dur: ms(12)}, {Name: "app", Dur: ms(40)}}},
+		{[]string{`db;dur=12.5;desc="Database"`}, []ServerTiming{{Name: "db", Dur: ms(12.5), Desc: "Database"}}},
+		{[]string{"cache;desc=HIT"}, []ServerTiming{{Name: "cache", Desc: "HIT"}}}, // no duration
+		// separators inside quotes, and spacing and case as servers send them
+		{[]string{`edge; DESC="a, b; c" ; Dur = 3`}, []ServerTiming{{Name: "edge", Dur: ms(3), Desc: "a, b; c"}}},
+		// more than one header, and the first of each name kept
+		{[]string{"db;dur=1", "db;dur=2, total;dur=9"}, []ServerTiming{{Name: "db", Dur: ms(1)}, {Name: "total", Dur: ms(9)}}},
+		// a bad duration is left out, and an empty entry skipped
+		{[]string{"db;dur=x,, app;dur=4;foo"}, []ServerTiming{{Name: "db"}, {Name: "app", Dur: ms(4)}}},
+	}
+	for _, test := range tests {
+		h := http.Header{"Server-Timing": test.headers}
+		if got := ParseServerTiming(h); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseServerTiming(%q) = %+v, want %+v", test.headers, got, test.want)
+		}
+	}
+}
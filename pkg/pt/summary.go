@@ -0,0 +1,230 @@
+package pt
+
+//  Per-target aggregation of PingTimes results
+
+import (
+	"sync"
+	"time"
+)
+
+// Summary accumulates the results of testing one target.  It is updated by
+// the goroutine testing the target and may be read concurrently (by the web
+// server, for example) through Report.
+type Summary struct {
+	mu       sync.Mutex
+	url      string
+	location string
+	groupBy  string    // what the results are grouped by, like "Remote", if grouped
+	group    string    // the group of results summarized, like the remote address
+	count    int64     // valid samples
+	fails    int64     // requests that returned no result
+	anomaly  int64     // samples with anomalies
+	sum      PingTimes // sum of each time component, and of Size
+	apdex    Apdex     // since start
+	recent   *ApdexWindow
+	pairs    int64                 // IPv4 and IPv6 result pairs, see AddPair
+	delta    time.Duration         // sum of IPv6 - IPv4 response times of the pairs
+	downs    int64                 // samples with a download throughput, summed in sum.Throughput
+	ups      int64                 // samples with an upload throughput, summed in sum.UploadThroughput
+	timing   map[string]*Histogram // Server-Timing durations by metric name
+	timings  []string              // keys of timing, in order first seen
+}
+
+// NewSummary returns an empty Summary for the target, computing Apdex with
+// the target's threshold both cumulatively and over a rolling window.
+func NewSummary(t *Target, location string, window time.Duration) *Summary {
+	return &Summary{
+		url:      t.Url,
+		location: location,
+		apdex:    Apdex{T: t.ApdexT()},
+		recent:   NewApdexWindow(t.ApdexT(), window),
+	}
+}
+
+// NewGroupSummary is like NewSummary, for a group of the results of the target:
+// for example groupBy "Remote" and group "10.1.2.3" for the results from one of
+// its addresses (see Target.AllAddrs).
+func NewGroupSummary(t *Target, groupBy, group, location string, window time.Duration) *Summary {
+	s := NewSummary(t, location, window)
+	s.groupBy = groupBy
+	s.group = group
+	return s
+}
+
+// Add records a result, where nil means the request could not be made.
+func (s *Summary) Add(pt *PingTimes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.apdex.Add(pt)
+	s.recent.Add(now, pt)
+	if pt == nil {
+		s.fails++
+		return
+	}
+
+	if s.count == 0 {
+		s.sum.Start = pt.Start
+	}
+	s.count++
+	s.sum.DnsLk += pt.DnsLk
+	s.sum.TcpHs += pt.TcpHs
+	s.sum.TlsHs += pt.TlsHs
+	s.sum.Reply += pt.Reply
+	s.sum.Close += pt.Close
+	s.sum.Total += pt.RespTime()
+	s.sum.Size += pt.Size
+	if len(pt.Anomalies) > 0 {
+		s.anomaly++
+	}
+	if pt.Throughput > 0 {
+		s.downs++
+		s.sum.Throughput += pt.Throughput
+	}
+	if pt.UploadThroughput > 0 {
+		s.ups++
+		s.sum.UploadThroughput += pt.UploadThroughput
+	}
+	for _, m := range pt.ServerTiming {
+		if m.Dur <= 0 {
+			continue
+		}
+		if s.timing == nil {
+			s.timing = make(map[string]*Histogram)
+		}
+		h := s.timing[m.Name]
+		if h == nil {
+			h = &Histogram{}
+			s.timing[m.Name] = h
+			s.timings = append(s.timings, m.Name)
+		}
+		h.Add(m.Dur)
+	}
+	// TODO: record changes in Remote Server IP from DNS resolution
+	// TODO: record count of different RespCode HTTP response code seen
+}
+
+// AddPair records the difference in response time between an IPv4 and an IPv6
+// result from the same test cycle (see Target.Family).  Add each result too.
+func (s *Summary) AddPair(v4, v6 *PingTimes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairs++
+	s.delta += v6.RespTime() - v4.RespTime()
+}
+
+// Window returns the width of the rolling window for recent Apdex.
+func (s *Summary) Window() time.Duration {
+	return s.recent.Width
+}
+
+// Count returns the number of valid samples recorded.
+func (s *Summary) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// ApdexScore is the JSON representation of an Apdex computation.
+type ApdexScore struct {
+	T          time.Duration // threshold for a satisfied response
+	Satisfied  int64
+	Tolerating int64
+	Frustrated int64
+	Score      float64 // zero if there are no samples
+}
+
+func scoreOf(a *Apdex) ApdexScore {
+	return ApdexScore{a.T, a.Satisfied, a.Tolerating, a.Frustrated, a.Score()}
+}
+
+// Samples returns the number of results counted in the score.
+func (a ApdexScore) Samples() int64 {
+	return a.Satisfied + a.Tolerating + a.Frustrated
+}
+
+// SummaryReport is a point in time copy of a Summary.  Time components are
+// averages over the valid samples, in milliseconds like MsecTsv.
+type SummaryReport struct {
+	Url         string
+	Location    string
+	GroupBy     string    `json:",omitempty"` // what results are grouped by, for a group summary
+	Group       string    `json:",omitempty"` // the group summarized
+	Start       time.Time // start of the first valid sample
+	Elapsed     time.Duration
+	Count       int64 // valid samples
+	Fails       int64 // requests that returned no result
+	Anomalies   int64 // samples with anomalies
+	DnsLk       float64
+	TcpHs       float64
+	TlsHs       float64
+	Reply       float64
+	Close       float64
+	Total       float64
+	Size        int64 // average response size
+	Apdex       ApdexScore
+	RecentApdex ApdexScore    // over the last RecentWidth
+	RecentWidth time.Duration // rolling window width
+	Pairs       int64         `json:",omitempty"` // IPv4 and IPv6 result pairs
+	V6Delta     float64       `json:",omitempty"` // average IPv6 - IPv4 response time of the pairs
+
+	Throughput       float64 `json:",omitempty"` // average download bytes per second
+	UploadThroughput float64 `json:",omitempty"` // average upload bytes per second
+
+	ServerTiming []ServerTimingHistogram `json:",omitempty"` // Server-Timing durations by metric
+}
+
+// ServerTimingHistogram is the distribution of the durations of one Server-Timing
+// metric in a SummaryReport.
+type ServerTimingHistogram struct {
+	Name string
+	Histogram
+}
+
+// Report returns a snapshot of the summary as of now.
+func (s *Summary) Report() SummaryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.recent.Expire(now)
+	r := SummaryReport{
+		Url:         s.url,
+		Location:    s.location,
+		GroupBy:     s.groupBy,
+		Group:       s.group,
+		Start:       s.sum.Start,
+		Count:       s.count,
+		Fails:       s.fails,
+		Anomalies:   s.anomaly,
+		Apdex:       scoreOf(&s.apdex),
+		RecentApdex: scoreOf(&s.recent.Apdex),
+		RecentWidth: s.recent.Width,
+	}
+	if s.count > 0 {
+		fc := float64(s.count)
+		r.Elapsed = now.Sub(s.sum.Start)
+		r.DnsLk = Msec(s.sum.DnsLk) / fc
+		r.TcpHs = Msec(s.sum.TcpHs) / fc
+		r.TlsHs = Msec(s.sum.TlsHs) / fc
+		r.Reply = Msec(s.sum.Reply) / fc
+		r.Close = Msec(s.sum.Close) / fc
+		r.Total = Msec(s.sum.Total) / fc
+		r.Size = s.sum.Size / s.count
+	}
+	if s.pairs > 0 {
+		r.Pairs = s.pairs
+		r.V6Delta = Msec(s.delta) / float64(s.pairs)
+	}
+	if s.downs > 0 {
+		r.Throughput = s.sum.Throughput / float64(s.downs)
+	}
+	if s.ups > 0 {
+		r.UploadThroughput = s.sum.UploadThroughput / float64(s.ups)
+	}
+	for _, name := range s.timings {
+		r.ServerTiming = append(r.ServerTiming, ServerTimingHistogram{name, s.timing[name].copy()})
+	}
+	return r
+}
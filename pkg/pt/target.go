@@ -0,0 +1,368 @@
+package pt
+
+//  Per-target test configuration
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Target holds the settings for one endpoint under test.  Targets named on the
+// command line or in PERFTEST_URL use the defaults from command line flags; a
+// JSON config file may list targets that override those defaults.  Zero values
+// mean "use the default".
+type Target struct {
+	Url       string // URL to test
+	ApdexMsec int64  // Apdex threshold T in milliseconds
+
+	AnomalySigmas float64           // flag components this many standard deviations over baseline
+	Assert        Assertions        // checks on the response content
+	HashBody      bool              // compute SHA-256 of the body to detect content changes
+	VerifyTLS     bool              // verify the server certificate chain; errors are failures
+	CertWarnDays  int               // alert when the server certificate expires within this many days
+	ClientCert    string            // PEM client certificate file, for mutual TLS
+	ClientKey     string            // PEM private key file for ClientCert
+	CAFile        string            // PEM root CA bundle to verify the server with (implies VerifyTLS)
+	ServerName    string            // TLS server name (SNI) to send and verify, instead of the URL host
+	Resolve       []string          // "host:port:addr" connects to addr instead of resolving host (like curl)
+	AllAddrs      bool              // resolve all addresses of the host and test each one every cycle
+	Family        string            // address family: "4" (IPv4 only), "6" (IPv6 only), or "both" (compare)
+	Resolver      string            // DNS server to look up the host with (see ParseResolver)
+	WsMessage     string            // text message to send on a WebSocket, timing the reply
+	WsRoundTrips  int               // number of times to send WsMessage on one connection (default 1)
+	Protocol      string            // HTTP protocol: "h1", "h2", "h2c" or "h3" (default negotiates h2 or HTTP/1.1)
+	Download      bool              // report the download throughput of the response body
+	CurveMsec     int64             // report download throughput over each interval of this many milliseconds
+	RangeBytes    int64             // request only the first this many bytes of the body (with a Range header)
+	UploadBytes   int64             // POST a generated payload of this many bytes, timing the upload
+	Encoding      string            // Accept-Encoding to send, like "gzip" or "br, zstd" (see Encodings)
+	Headers       []string          // response headers to record, where "cdn" means CDNHeaders
+	GroupByCache  bool              // summarize the results by cache status too (see CacheStatus)
+	TcpInfo       bool              // record the kernel TCP statistics of each connection (Linux only)
+	Steps         []Step            // requests of a transaction, making the target one (see FetchTransaction)
+	Vars          map[string]string // initial variables of a transaction, like credentials
+	Load          string            // load test stages, like "50-500@5m" (see ParseLoadStages)
+	LoadWorkers   int               // most requests in flight in a load test
+	Users         int               // virtual users making requests one after another (see RunUsers)
+	ThinkMsec     int64             // think time of a virtual user between requests
+	IntervalMsec  int64             // between tests of the target, at a fixed rate (see Schedule)
+	JitterMsec    int64             // most random delay of each test
+	OffsetMsec    int64             // of the first test from the start, to stagger targets
+	Cron          string            // test at the minutes of a cron expression instead, like "0 * * * *" (see Cron)
+
+	certs   []tls.Certificate // loaded from ClientCert and ClientKey
+	roots   *x509.CertPool    // loaded from CAFile, or nil for the system roots
+	resolve map[string]string // host:port to addr:port, from Resolve
+	pin     string            // addr:port to connect to, overriding everything else
+	dns     *Resolver         // from Resolver
+	headers []string          // from Headers, expanded
+	step    *stepRequest      // for a step of a transaction
+	stages  []LoadStage       // from Load
+	session *session          // to keep connections open, if not nil
+	cron    *Cron             // from Cron
+}
+
+// Config is the layout of the JSON config file, for example:
+//
+//	{ "Targets": [ { "Url": "https://www.google.com", "ApdexMsec": 250 } ] }
+type Config struct {
+	Targets     []Target
+	Maintenance []Window // maintenance windows of the targets
+}
+
+// ReadConfig loads a JSON config file.  Unknown fields are an error, to catch
+// typos in setting names that would otherwise be silently ignored.  The caller
+// should apply defaults and then Init each target.
+func ReadConfig(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", file, err)
+	}
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if len(t.Url) == 0 {
+			return nil, fmt.Errorf("%s: target %d has no Url", file, i+1)
+		}
+	}
+	return &cfg, nil
+}
+
+// Init checks the settings of the target and prepares them for use: it compiles
+// the assertions, loads TLS files, and parses Resolve entries.  Call it once,
+// after applying defaults, before testing the target.
+func (t *Target) Init() error {
+	if err := t.Assert.compile(); err != nil {
+		return err
+	}
+	if err := t.loadTLS(); err != nil {
+		return err
+	}
+	switch t.Family {
+	case "", "4", "6", "both":
+	default:
+		return fmt.Errorf("Family %q is not 4, 6 or both", t.Family)
+	}
+	grouped := 0
+	for _, g := range []bool{t.AllAddrs, t.Family == "both", t.GroupByCache} {
+		if g {
+			grouped++
+		}
+	}
+	if grouped > 1 {
+		return fmt.Errorf("AllAddrs, Family both and GroupByCache each group the results; choose one")
+	}
+	switch t.Protocol {
+	case "", "h1", "h2", "h2c", "h3":
+	default:
+		return fmt.Errorf("Protocol %q is not h1, h2, h2c or h3", t.Protocol)
+	}
+	if len(t.Load) > 0 {
+		var err error
+		if t.stages, err = ParseLoadStages(t.Load); err != nil {
+			return err
+		}
+		if t.LoadWorkers < 1 {
+			return fmt.Errorf("LoadWorkers %d is not positive", t.LoadWorkers)
+		}
+	}
+	if t.IntervalMsec < 0 || t.JitterMsec < 0 || t.OffsetMsec < 0 {
+		return fmt.Errorf("IntervalMsec, JitterMsec and OffsetMsec cannot be negative")
+	}
+	if t.RangeBytes < 0 || t.UploadBytes < 0 || t.CurveMsec < 0 {
+		return fmt.Errorf("RangeBytes, UploadBytes and CurveMsec cannot be negative")
+	}
+	if t.RangeBytes > 0 && t.UploadBytes > 0 {
+		return fmt.Errorf("RangeBytes is for a download, not an upload (UploadBytes)")
+	}
+	if t.UploadBytes > 0 && !t.Uploads() {
+		return fmt.Errorf("UploadBytes is for an HTTP request, not a transaction, WebSocket or other probe")
+	}
+	if t.Users < 0 || t.ThinkMsec < 0 {
+		return fmt.Errorf("Users %d and ThinkMsec %d cannot be negative", t.Users, t.ThinkMsec)
+	}
+	if t.Users > 0 && len(t.Load) > 0 {
+		return fmt.Errorf("Users and Load are different kinds of load test; choose one")
+	}
+	if len(t.Cron) > 0 {
+		if t.Users > 0 || len(t.Load) > 0 {
+			return fmt.Errorf("Cron schedules monitoring, not a load test")
+		}
+		var err error
+		if t.cron, err = ParseCron(t.Cron); err != nil {
+			return err
+		}
+	}
+	if t.TcpInfo && !tcpInfoSupported {
+		return fmt.Errorf("TcpInfo is only supported on Linux, other than 386")
+	}
+	t.headers = expandHeaders(t.Headers)
+	if len(t.Encoding) > 0 {
+		if err := checkEncoding(t.Encoding); err != nil {
+			return err
+		}
+	}
+	if t.Protocol == "h2c" && strings.HasPrefix(t.Url, "https:") {
+		return fmt.Errorf("Protocol h2c is HTTP/2 without TLS, not for https URLs")
+	}
+	if t.Protocol == "h3" {
+		if strings.HasPrefix(t.Url, "http:") {
+			return fmt.Errorf("Protocol h3 is HTTP/3 over QUIC, with TLS, not for http URLs")
+		}
+		if t.TcpInfo {
+			return fmt.Errorf("TcpInfo is for TCP connections, not QUIC (Protocol h3)")
+		}
+	}
+	if len(t.Resolver) > 0 {
+		var err error
+		if t.dns, err = ParseResolver(t.Resolver); err != nil {
+			return err
+		}
+	}
+	if len(t.Steps) > 0 {
+		if err := t.compileSteps(); err != nil {
+			return err
+		}
+	} else if strings.HasPrefix(t.Url, "dns://") {
+		if _, err := parseDNSQuery(t); err != nil {
+			return err
+		}
+	} else if scheme := strings.SplitN(t.Url, "://", 2)[0]; scheme == "tcp" || scheme == "tls" || scheme == "grpc" || scheme == "grpcs" {
+		if _, _, _, err := connAddr(t); err != nil {
+			return err
+		}
+	}
+	t.resolve = make(map[string]string)
+	for _, entry := range t.Resolve {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("Resolve %q is not host:port:addr", entry)
+		}
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("Resolve %q: %q is not an IP address", entry, addr)
+		}
+		t.resolve[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(addr, parts[1])
+	}
+	return nil
+}
+
+// network returns the network to dial for the target's address family.
+func (t *Target) network(network string) string {
+	switch t.Family {
+	case "4":
+		return network + "4"
+	case "6":
+		return network + "6"
+	}
+	return network
+}
+
+// familyName returns the name of the address family of the target, or "" if
+// it is not limited to one.
+func (t *Target) familyName() string {
+	switch t.Family {
+	case "4":
+		return "IPv4"
+	case "6":
+		return "IPv6"
+	}
+	return ""
+}
+
+// lookup resolves host with the target's Resolver, returning the addresses of
+// the target's address family (IPv4 first, if either will do) and the answer to
+// the last query made.
+func (t *Target) lookup(ctx context.Context, host string) ([]net.IP, *DNSInfo, error) {
+	var types []dnsmessage.Type
+	switch t.Family {
+	case "4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	var info *DNSInfo
+	for _, qtype := range types {
+		var err error
+		if info, err = t.dns.Query(ctx, host, qtype); err != nil {
+			return nil, info, err
+		}
+		if ips := info.IPs(); len(ips) > 0 {
+			return ips, info, nil
+		}
+	}
+	return nil, info, fmt.Errorf("lookup %s on %s: no addresses (%s)", host, t.Resolver, info.Rcode)
+}
+
+// lookupAll is like lookup but returns all the addresses of the host (of the
+// target's address family), with the answers to the queries combined.
+func (t *Target) lookupAll(ctx context.Context, host string) ([]net.IP, *DNSInfo, error) {
+	if len(t.Family) == 1 {
+		return t.lookup(ctx, host)
+	}
+	v4, v6 := *t, *t
+	v4.Family, v6.Family = "4", "6"
+	ips4, info, err4 := v4.lookup(ctx, host)
+	ips6, info6, err6 := v6.lookup(ctx, host)
+	if err4 != nil && err6 != nil {
+		return nil, info, err4
+	}
+	if info == nil {
+		info = info6
+	} else if info6 != nil {
+		info.Type += "," + info6.Type
+		if len(info6.Answers) > 0 && (len(info.Answers) == 0 || info6.TTL < info.TTL) {
+			info.TTL = info6.TTL
+		}
+		info.Answers = append(info.Answers, info6.Answers...)
+	}
+	return append(ips4, ips6...), info, nil
+}
+
+// dialAddr returns the address to connect to for addr (host:port), applying any
+// pinned address or Resolve entry.
+func (t *Target) dialAddr(addr string) string {
+	if len(t.pin) > 0 {
+		return t.pin
+	}
+	if pinned, found := t.resolve[addr]; found {
+		return pinned
+	}
+	return addr
+}
+
+// loadTLS loads the client certificate and root CAs named by the target.
+func (t *Target) loadTLS() error {
+	if len(t.ClientCert) > 0 || len(t.ClientKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return fmt.Errorf("client certificate: %v", err)
+		}
+		t.certs = []tls.Certificate{cert}
+	}
+	if len(t.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return fmt.Errorf("CA bundle: %v", err)
+		}
+		t.roots = x509.NewCertPool()
+		if !t.roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("CA bundle: no certificates in %s", t.CAFile)
+		}
+		t.VerifyTLS = true
+	}
+	return nil
+}
+
+// tlsClientConfig returns the TLS configuration for requests to the target.
+// The server certificate is not verified during the handshake, so the timing
+// of a request to a server with a bad certificate is still measured; it is
+// verified afterwards by NewTLSInfo if VerifyTLS is set.
+func (t *Target) tlsClientConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, // Warning: skips CA checks, but ping doesn't care
+		ServerName:         t.ServerName,
+		Certificates:       t.certs,
+	}
+}
+
+// Uploads returns true if the target is a single HTTP request that may POST an
+// UploadBytes payload: not a transaction, whose steps have their own Method and
+// Body, a ranged download, or a WebSocket, DNS or connection probe.
+func (t *Target) Uploads() bool {
+	if len(t.Steps) > 0 || t.RangeBytes > 0 {
+		return false
+	}
+	url := ParseURL(t.Url)
+	return url != nil && (url.Scheme == "http" || url.Scheme == "https")
+}
+
+// hashBody returns true if the response body should be hashed.
+func (t *Target) hashBody() bool {
+	return t.HashBody || len(t.Assert.Sha256) > 0
+}
+
+// ApdexT returns the Apdex threshold T for the target.
+func (t *Target) ApdexT() time.Duration {
+	return time.Duration(t.ApdexMsec) * time.Millisecond
+}
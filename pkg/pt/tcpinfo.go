@@ -0,0 +1,37 @@
+package pt
+
+//  Kernel TCP statistics of probe connections (see Target.TcpInfo)
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPInfo is the kernel's view of a TCP connection, read with getsockopt
+// TCP_INFO just before it closes.  Retransmits and Lost point to loss in the
+// network, where a slow reply with none suggests a slow server.
+type TCPInfo struct {
+	RTT         time.Duration // smoothed round trip time
+	RTTVar      time.Duration // round trip time variance
+	Retransmits uint32        // segments retransmitted over the life of the connection
+	Lost        uint32        // segments currently considered lost
+	SndCwnd     uint32        // congestion window, in segments
+	SndMss      uint32        // maximum segment size sent
+	RcvMss      uint32        // maximum segment size received
+}
+
+// tcpInfoConn reads the TCP_INFO of a connection into a PingTimes when it is
+// first closed, for probes that hand their connection on and close it when
+// done.  Another goroutine may close it too, like an HTTP/2 client's reader.
+type tcpInfoConn struct {
+	net.Conn          // as used, maybe over TLS
+	raw      net.Conn // the TCP connection underneath
+	pt       *PingTimes
+	once     sync.Once
+}
+
+func (c *tcpInfoConn) Close() error {
+	c.once.Do(func() { c.pt.TCPInfo = readTCPInfo(c.raw) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,47 @@
+//go:build linux && !386
+// +build linux,!386
+
+package pt
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpInfoSupported is whether readTCPInfo works on this platform.  It does not
+// on linux/386, where getsockopt is multiplexed through socketcall.
+const tcpInfoSupported = true
+
+// readTCPInfo returns the kernel TCP statistics of conn, or nil if they cannot
+// be read.
+func readTCPInfo(conn net.Conn) *TCPInfo {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 { // closed already
+		return nil
+	}
+	return &TCPInfo{
+		RTT:         time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:      time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits: info.Total_retrans,
+		Lost:        info.Lost,
+		SndCwnd:     info.Snd_cwnd,
+		SndMss:      info.Snd_mss,
+		RcvMss:      info.Rcv_mss,
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux || 386
+// +build !linux 386
+
+package pt
+
+import (
+	"net"
+)
+
+// tcpInfoSupported is whether readTCPInfo works on this platform.
+const tcpInfoSupported = false
+
+// readTCPInfo returns nil, as TCP_INFO is only read on Linux, and not on 386.
+func readTCPInfo(conn net.Conn) *TCPInfo {
+	return nil
+}
@@ -0,0 +1,74 @@
+package pt
+
+//  Throughput of downloads and uploads
+
+import (
+	"io"
+	"math/rand"
+	"time"
+)
+
+// BytesPerSec returns the rate of n bytes transferred in d, or zero if either
+// is zero.
+func BytesPerSec(n int64, d time.Duration) float64 {
+	if n <= 0 || d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// curveWriter counts the bytes written to it in each interval, from the first
+// write, recording the rate in bytes per second of each interval.  It never
+// returns an error, so it can be used in an io.MultiWriter.
+type curveWriter struct {
+	interval time.Duration
+	start    time.Time // of the current interval
+	bytes    int64     // in the current interval
+	curve    []float64 // bytes per second of each completed interval
+}
+
+func (c *curveWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if c.start.IsZero() {
+		c.start = now
+	}
+	for now.Sub(c.start) >= c.interval {
+		c.curve = append(c.curve, BytesPerSec(c.bytes, c.interval))
+		c.start = c.start.Add(c.interval)
+		c.bytes = 0
+	}
+	c.bytes += int64(len(p))
+	return len(p), nil
+}
+
+// Curve returns the rate of each interval, including the last partial one
+// ending at end.
+func (c *curveWriter) Curve(end time.Time) []float64 {
+	if c.bytes > 0 {
+		return append(c.curve, BytesPerSec(c.bytes, end.Sub(c.start)))
+	}
+	return c.curve
+}
+
+// payloadReader generates n bytes of random data to upload, so compression
+// along the way does not make the upload faster than it should be.
+type payloadReader struct {
+	n   int64
+	rnd *rand.Rand
+}
+
+func newPayloadReader(n int64) *payloadReader {
+	return &payloadReader{n: n, rnd: rand.New(rand.NewSource(n))}
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, _ := r.rnd.Read(p)
+	r.n -= int64(n)
+	return n, nil
+}
@@ -0,0 +1,68 @@
+package pt
+
+//  TLS connection and server certificate details
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
+// TLSInfo describes the negotiated TLS connection and the server's (leaf)
+// certificate.
+type TLSInfo struct {
+	Version     string    // protocol version, like "TLS 1.3"
+	CipherSuite string    // like "TLS_AES_128_GCM_SHA256"
+	ALPN        string    // negotiated application protocol, if any
+	Subject     string    // leaf certificate subject
+	SANs        []string  // subject alternative names: DNS names and IP addresses
+	Issuer      string    // leaf certificate issuer
+	NotAfter    time.Time // leaf certificate expiry
+	VerifyError string    `json:",omitempty"` // chain verification error (strict mode only)
+}
+
+// NewTLSInfo extracts the TLSInfo from a connection state.  If verify is true it
+// also verifies the server's certificate chain for serverName against roots (or
+// the system root CAs if roots is nil), and records any error.
+func NewTLSInfo(cs *tls.ConnectionState, serverName string, verify bool, roots *x509.CertPool) *TLSInfo {
+	info := &TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+	}
+	if len(cs.PeerCertificates) == 0 {
+		if verify {
+			info.VerifyError = "no server certificate"
+		}
+		return info
+	}
+
+	leaf := cs.PeerCertificates[0]
+	info.Subject = leaf.Subject.String()
+	info.Issuer = leaf.Issuer.String()
+	info.NotAfter = leaf.NotAfter
+	info.SANs = append(info.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+
+	if verify {
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(opts); err != nil {
+			info.VerifyError = err.Error()
+		}
+	}
+	return info
+}
+
+// ExpiresWithin returns true if the certificate expires less than d after now.
+func (info *TLSInfo) ExpiresWithin(now time.Time, d time.Duration) bool {
+	return !info.NotAfter.IsZero() && info.NotAfter.Sub(now) < d
+}
@@ -0,0 +1,73 @@
+package pt
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchTLSInfo(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target Target
+		failed bool // verification
+	}{
+		{Target{}, false},                                       // not checked
+		{Target{VerifyTLS: true}, true},                         // by an unknown authority
+		{Target{CAFile: ca}, false},                             // trusted
+		{Target{CAFile: ca, ServerName: "other.example"}, true}, // not the name of the certificate
+		{Target{CAFile: ca, ServerName: "example.com"}, false},  // another of its names
+	}
+	for i, test := range tests {
+		target := test.target
+		target.Url = server.URL
+		if err := target.Init(); err != nil {
+			t.Fatal(err)
+		}
+		pt := FetchTarget(&target, "test")
+		if pt == nil || pt.TLS == nil {
+			t.Fatalf("target %d: no TLS info in %+v", i, pt)
+		}
+		if failed := len(pt.TLS.VerifyError) > 0; failed != test.failed || pt.Failed() != failed {
+			t.Errorf("target %d: VerifyError %q, failed %v, want failed %v", i, pt.TLS.VerifyError, pt.Failed(), test.failed)
+		}
+		if !pt.TLS.NotAfter.Equal(server.Certificate().NotAfter) || len(pt.TLS.Version) == 0 {
+			t.Errorf("target %d: TLS info %+v does not describe the server certificate", i, pt.TLS)
+		}
+	}
+}
+
+func TestExpiresWithin(t *testing.T) {
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	info := &TLSInfo{NotAfter: now.Add(30 * 24 * time.Hour)}
+	tests := []struct {
+		d      time.Duration
+		within bool
+	}{
+		{29 * 24 * time.Hour, false},
+		{30 * 24 * time.Hour, false}, // expires exactly then
+		{30*24*time.Hour + 1, true},
+		{31 * 24 * time.Hour, true},
+	}
+	for _, test := range tests {
+		if within := info.ExpiresWithin(now, test.d); within != test.within {
+			t.Errorf("ExpiresWithin(%s) = %v, want %v", test.d, within, test.within)
+		}
+	}
+	if !info.ExpiresWithin(now.Add(31*24*time.Hour), time.Hour) {
+		t.Error("an expired certificate does not expire within an hour")
+	}
+	if (&TLSInfo{}).ExpiresWithin(now, 24*time.Hour) {
+		t.Error("no certificate expires within a day")
+	}
+}
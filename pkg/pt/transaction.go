@@ -0,0 +1,232 @@
+package pt
+
+//  Multi-step transactions, like logging in, fetching a page and logging out
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Step is one request of a transaction (see Target.Steps).  Its Url, Headers
+// and Body may use variables as ${name}, set by the Vars of the target or by
+// the extractors of earlier steps.
+type Step struct {
+	Name    string               // for reports (default "step N")
+	Method  string               // HTTP method (default GET, or POST with a Body)
+	Url     string               // URL to request
+	Headers map[string]string    // request headers to send
+	Body    string               // request body to send
+	Assert  Assertions           // checks on the response
+	Extract map[string]Extractor // variables to set from the response, by name
+}
+
+// Extractor sets a variable from a step's response, from one of: the value at
+// a JSON path in the body, the first submatch of a regular expression in the
+// body (or the whole match, without a submatch), or a response header.
+type Extractor struct {
+	JsonPath string // like "$.token"
+	Regex    string // like `name="csrf" value="([^"]+)"`
+	Header   string // like "Location"
+
+	regex *regexp.Regexp
+}
+
+// stepRequest is what FetchTarget does differently for a step, and what it
+// keeps of the response for the extractors.
+type stepRequest struct {
+	method string
+	body   string
+	header http.Header
+	jar    http.CookieJar
+
+	respHeader http.Header // after FetchTarget
+	respBody   []byte      // up to MaxAssertBody
+}
+
+// stepJar returns the cookie jar shared by the steps of a transaction, or nil
+// if the target is not a step.
+func (t *Target) stepJar() http.CookieJar {
+	if t.step == nil {
+		return nil
+	}
+	return t.step.jar
+}
+
+var varRef = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expand replaces each ${name} in s with the value of the variable, leaving
+// references to unset variables as they are.
+func expand(s string, vars map[string]string) string {
+	return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, found := vars[ref[2:len(ref)-1]]; found {
+			return value
+		}
+		return ref
+	})
+}
+
+// compileSteps checks the steps of a transaction and compiles their
+// assertions and extractors.  The target itself may not have assertions, as
+// it makes no request of its own.
+func (t *Target) compileSteps() error {
+	if !t.Assert.isZero() {
+		return fmt.Errorf("a transaction checks the Assert of each of its Steps, not its own")
+	}
+	for i := range t.Steps {
+		s := &t.Steps[i]
+		if len(s.Name) == 0 {
+			s.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if len(s.Url) == 0 {
+			return fmt.Errorf("%s has no Url", s.Name)
+		}
+		if err := s.Assert.compile(); err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		for name, x := range s.Extract {
+			sources := 0
+			for _, source := range []string{x.JsonPath, x.Regex, x.Header} {
+				if len(source) > 0 {
+					sources++
+				}
+			}
+			if sources != 1 {
+				return fmt.Errorf("%s: Extract %s needs one of JsonPath, Regex or Header", s.Name, name)
+			}
+			var err error
+			if len(x.JsonPath) > 0 {
+				_, err = parseJsonPath(x.JsonPath)
+			} else if len(x.Regex) > 0 {
+				x.regex, err = regexp.Compile(x.Regex)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: Extract %s: %v", s.Name, name, err)
+			}
+			s.Extract[name] = x
+		}
+	}
+	return nil
+}
+
+// extract returns the value of the variable from the response to a step.
+func (x *Extractor) extract(header http.Header, body []byte) (string, error) {
+	switch {
+	case len(x.Header) > 0:
+		if values, found := header[http.CanonicalHeaderKey(x.Header)]; found {
+			return strings.Join(values, ", "), nil
+		}
+		return "", fmt.Errorf("header %s missing", x.Header)
+	case x.regex != nil:
+		m := x.regex.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("body does not match %q", x.Regex)
+		}
+		return string(m[len(m)-1]), nil
+	default:
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return "", fmt.Errorf("body is not JSON: %v", err)
+		}
+		return JsonPathValue(doc, x.JsonPath)
+	}
+}
+
+// FetchTransaction runs the steps of a transaction target in order, with a
+// cookie jar shared between them, and returns the result of the transaction
+// with the result of each step in Steps.  The transaction stops at the first
+// step that fails.  Its times are the sums of those of the steps, so Total is
+// the time of the whole transaction not including DNS lookups or the time
+// between steps; its RespCode and Remote are those of the last step run.
+func FetchTransaction(t *Target, myLocation string) *PingTimes {
+	jar, _ := cookiejar.New(nil) // never fails without options
+	vars := make(map[string]string)
+	for name, value := range t.Vars {
+		vars[name] = value
+	}
+
+	urlStr := t.Url
+	ptResult := &PingTimes{
+		Start:    time.Now(),
+		DestUrl:  &urlStr,
+		Location: &myLocation,
+		RespCode: 520,
+		Family:   t.familyName(),
+	}
+	for i := range t.Steps {
+		s := &t.Steps[i]
+		header := make(http.Header)
+		for name, value := range s.Headers {
+			header.Set(name, expand(value, vars))
+		}
+		method := s.Method
+		if len(method) == 0 && len(s.Body) > 0 {
+			method = http.MethodPost
+		}
+		step := *t
+		step.Url = expand(s.Url, vars)
+		step.Steps = nil
+		step.Assert = s.Assert
+		step.UploadBytes = 0
+		step.step = &stepRequest{method: method, body: expand(s.Body, vars), header: header, jar: jar}
+
+		stepResult := FetchTarget(&step, myLocation)
+		if stepResult == nil {
+			ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("%s: cannot request %s", s.Name, step.Url))
+			break
+		}
+		stepResult.Step = s.Name
+		stepResult.RespTime()
+		ptResult.Steps = append(ptResult.Steps, stepResult)
+		ptResult.DnsLk += stepResult.DnsLk
+		ptResult.TcpHs += stepResult.TcpHs
+		ptResult.TlsHs += stepResult.TlsHs
+		ptResult.Reply += stepResult.Reply
+		ptResult.Close += stepResult.Close
+		ptResult.Total += stepResult.Total
+		ptResult.Size += stepResult.Size
+		ptResult.newConns += stepResult.newConns
+		ptResult.RespCode = stepResult.RespCode
+		ptResult.Remote = stepResult.Remote
+		if stepResult.TLS != nil {
+			ptResult.TLS = stepResult.TLS
+		}
+
+		if sr := step.step; sr.respHeader != nil && !stepResult.Failed() {
+			names := make([]string, 0, len(s.Extract))
+			for name := range s.Extract {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				x := s.Extract[name]
+				value, err := x.extract(sr.respHeader, sr.respBody)
+				if err != nil {
+					stepResult.AssertFailures = append(stepResult.AssertFailures, fmt.Sprintf("extract %s: %v", name, err))
+					continue
+				}
+				vars[name] = value
+			}
+		}
+		for _, failure := range stepResult.AssertFailures {
+			ptResult.AssertFailures = append(ptResult.AssertFailures, s.Name+": "+failure)
+		}
+		if stepResult.Failed() {
+			if len(stepResult.AssertFailures) == 0 {
+				ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("%s: status %d", s.Name, stepResult.RespCode))
+			}
+			log.Printf("%s: %s failed", t.Url, s.Name)
+			break
+		}
+	}
+	return ptResult
+}
@@ -0,0 +1,92 @@
+package pt
+
+//  Closed-model load: virtual users each making requests one after another
+
+import (
+	"sync"
+	"time"
+)
+
+// UserReport is the result of a virtual user in a test with RunUsers, or of
+// all of them together.
+type UserReport struct {
+	Url      string
+	User     int           // from 1, or 0 for all users
+	Requests int64         // made, including errors
+	Errors   int64         // failed requests, or those that returned no result
+	Reused   int64         // requests on a connection kept open from a previous one
+	NewConns int64         // connections made, by requests not on a kept one
+	Elapsed  time.Duration // from the first request to the last response
+	Rate     float64       // requests per second
+	Latency  Histogram     // of response times
+}
+
+// RunUsers tests the target with users virtual users at once, each making
+// requests one after another, with think time between them, on connections
+// kept open (see WithSession).  Each user makes requests requests, or with zero,
+// continues until done is closed.  Each result is passed to onResult, from the
+// user that fetched it.  Returns the report of each user, and of all together.
+func RunUsers(t *Target, myLocation string, users int, think time.Duration, requests int, done <-chan int, onResult func(*PingTimes)) ([]UserReport, UserReport) {
+	each := make([]UserReport, users)
+	var wg sync.WaitGroup
+	for u := range each {
+		wg.Add(1)
+		go func(r *UserReport) {
+			defer wg.Done()
+			user := t.WithSession()
+			defer user.CloseSession()
+			start := time.Now()
+			for requests == 0 || r.Requests < int64(requests) {
+				select {
+				case <-done:
+					r.Elapsed = time.Since(start)
+					return
+				default:
+				}
+				ptResult := FetchTarget(user, myLocation)
+				onResult(ptResult)
+				r.Requests++
+				if ptResult == nil || ptResult.Failed() {
+					r.Errors++
+				}
+				if ptResult != nil {
+					r.Latency.Add(ptResult.RespTime())
+					if ptResult.Reused {
+						r.Reused++
+					}
+					r.NewConns += int64(ptResult.newConns)
+				}
+				r.Elapsed = time.Since(start)
+				if think > 0 && (requests == 0 || r.Requests < int64(requests)) {
+					select {
+					case <-done:
+						return
+					case <-time.After(think):
+					}
+				}
+			}
+		}(&each[u])
+	}
+	wg.Wait()
+
+	all := UserReport{Url: t.Url}
+	for i := range each {
+		r := &each[i]
+		r.Url, r.User = t.Url, i+1
+		if r.Elapsed > 0 {
+			r.Rate = float64(r.Requests) / r.Elapsed.Seconds()
+		}
+		all.Requests += r.Requests
+		all.Errors += r.Errors
+		all.Reused += r.Reused
+		all.NewConns += r.NewConns
+		all.Latency.Merge(&r.Latency)
+		if r.Elapsed > all.Elapsed {
+			all.Elapsed = r.Elapsed
+		}
+	}
+	if all.Elapsed > 0 {
+		all.Rate = float64(all.Requests) / all.Elapsed.Seconds()
+	}
+	return each, all
+}
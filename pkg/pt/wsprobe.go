@@ -0,0 +1,223 @@
+package pt
+
+//  WebSocket probe: times the connection and upgrade to a ws:// or wss:// target,
+//  and optionally message round trips, returning PingTimes
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455)
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsGUID is appended to the key to compute Sec-WebSocket-Accept.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// FetchWS connects to a ws:// or wss:// target and upgrades the connection to a
+// WebSocket.  If the target has a WsMessage it sends it and waits for a reply,
+// WsRoundTrips times on the same connection, and then closes.  The PingTimes
+// has the DNS lookup, TCP and TLS handshake times, Reply the time to upgrade,
+// Close the time of the round trips (each one in EchoRTT), and Size the bytes
+// of the replies.  RespCode is the HTTP status, 101 if upgraded, or 520 if no
+// response.  The assertions check the upgrade response and the last reply.
+func FetchWS(t *Target, myLocation string) *PingTimes {
+	url := ParseURL(t.Url)
+	if url == nil {
+		log.Println("cannot parse URL", t.Url)
+		return nil
+	}
+	host, port := url.Hostname(), url.Port()
+	if len(port) == 0 {
+		port = defaultPorts[url.Scheme]
+	}
+
+	ptResult := newConnResult(t, myLocation)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := t.connect(ctx, host, port, url.Scheme == "wss", []string{"http/1.1"}, ptResult)
+	if err != nil {
+		log.Printf("connect %s: %v", t.Url, err)
+		return ptResult
+	}
+	defer conn.Close()
+	if deadline, found := ctx.Deadline(); found {
+		conn.SetDeadline(deadline)
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	path := url.RequestURI()
+	tConnd := time.Now()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, url.Host, key)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	tFirst := time.Now()
+	ptResult.Reply = tFirst.Sub(tConnd)
+	ptResult.Total += ptResult.Reply
+	if err != nil {
+		log.Printf("upgrade %s: %v", t.Url, err)
+		return ptResult
+	}
+	ptResult.RespCode = resp.StatusCode
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		ptResult.AssertFailures = append(ptResult.AssertFailures, "no upgrade: status "+resp.Status)
+		return ptResult
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		ptResult.AssertFailures = append(ptResult.AssertFailures, "bad Sec-WebSocket-Accept")
+		return ptResult
+	}
+
+	var reply []byte
+	if len(t.WsMessage) > 0 {
+		trips := t.WsRoundTrips
+		if trips < 1 {
+			trips = 1
+		}
+		for i := 0; i < trips; i++ {
+			tSent := time.Now()
+			if err = wsWriteFrame(conn, wsText, []byte(t.WsMessage)); err == nil {
+				reply, err = wsReadMessage(br, conn)
+			}
+			if err != nil {
+				log.Printf("WebSocket %s: %v", t.Url, err)
+				ptResult.AssertFailures = append(ptResult.AssertFailures, fmt.Sprintf("round trip %d: %v", i+1, err))
+				break
+			}
+			ptResult.EchoRTT = append(ptResult.EchoRTT, time.Since(tSent))
+			ptResult.Size += int64(len(reply))
+		}
+		ptResult.Close = time.Since(tFirst)
+		ptResult.Total += ptResult.Close
+	}
+	wsWriteFrame(conn, wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+
+	if err == nil {
+		ptResult.AssertFailures = append(ptResult.AssertFailures, t.Assert.Check(ptResult, resp.Header, reply)...)
+	}
+	return ptResult
+}
+
+// wsWriteFrame writes a single masked frame, as a client must.
+func wsWriteFrame(w io.Writer, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode} // FIN
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(n))
+		frame = append(append(frame, 0x80|127), length[:]...)
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// wsReadMessage reads frames until a complete data message, answering pings,
+// and returns its payload (up to MaxAssertBody; the rest is discarded).
+func wsReadMessage(r *bufio.Reader, w io.Writer) ([]byte, error) {
+	var msg limitedBuffer
+	msg.max = MaxAssertBody
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		var mask []byte // servers should not mask, but may
+		if header[1]&0x80 != 0 {
+			mask = make([]byte, 4)
+			if _, err := io.ReadFull(r, mask); err != nil {
+				return nil, err
+			}
+		}
+
+		switch opcode {
+		case wsPing, wsPong, wsClose:
+			if length > 125 {
+				return nil, fmt.Errorf("control frame of %d bytes", length)
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return nil, err
+			}
+			for i := range mask {
+				for j := i; j < len(payload); j += 4 {
+					payload[j] ^= mask[i]
+				}
+			}
+			if opcode == wsClose {
+				return nil, errors.New("connection closed by server")
+			}
+			if opcode == wsPing {
+				if err := wsWriteFrame(w, wsPong, payload); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		payload := make([]byte, 4096)
+		for read := uint64(0); read < length; {
+			chunk := payload
+			if left := length - read; left < uint64(len(chunk)) {
+				chunk = chunk[:left]
+			}
+			n, err := io.ReadFull(r, chunk)
+			for i := 0; mask != nil && i < n; i++ {
+				chunk[i] ^= mask[(read+uint64(i))%4]
+			}
+			msg.Write(chunk[:n])
+			read += uint64(n)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if fin { // the last frame of the message
+			return msg.Bytes(), nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package pt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// unmasked returns a frame as a server sends it, without a mask.
+func unmasked(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(n))
+		frame = append(append(frame, 127), length[:]...)
+	}
+	return append(frame, payload...)
+}
+
+func TestWsFrames(t *testing.T) {
+	// masked frames of each length encoding read back as written
+	for _, n := range []int{0, 5, 125, 126, 200, 0xffff, 70000} {
+		payload := bytes.Repeat([]byte("perftest"), n/8+1)[:n]
+		var wire bytes.Buffer
+		if err := wsWriteFrame(&wire, wsText, payload); err != nil {
+			t.Fatal(err)
+		}
+		if b := wire.Bytes(); b[0] != 0x80|wsText || b[1]&0x80 == 0 {
+			t.Errorf("%d bytes: frame header % x is not a final, masked text frame", n, b[:2])
+		}
+		if n > 0 && bytes.Contains(wire.Bytes(), payload) {
+			t.Errorf("%d bytes: payload not masked", n)
+		}
+		msg, err := wsReadMessage(bufio.NewReader(&wire), &bytes.Buffer{})
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(msg, payload) {
+			t.Errorf("%d bytes: read back %d bytes that differ", n, len(msg))
+		}
+	}
+}
+
+func TestWsReadMessage(t *testing.T) {
+	// a ping between the fragments of a message, answered with a pong
+	var wire bytes.Buffer
+	wire.Write(unmasked(false, wsText, []byte("hello, ")))
+	wire.Write(unmasked(true, wsPing, []byte("are you there")))
+	wire.Write(unmasked(true, 0x0, []byte("world"))) // continuation
+	var sent bytes.Buffer
+	msg, err := wsReadMessage(bufio.NewReader(&wire), &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello, world" {
+		t.Errorf("message %q, want %q", msg, "hello, world")
+	}
+	pong, err := wsReadMessage(bufio.NewReader(bytes.NewReader(unmaskedPong(t, sent.Bytes()))), &bytes.Buffer{})
+	if err != nil || string(pong) != "are you there" {
+		t.Errorf("answered the ping with %q (%v), want a pong of its payload", pong, err)
+	}
+
+	// a close, or a control frame that is too long, is an error
+	for _, frame := range [][]byte{unmasked(true, wsClose, nil), unmasked(true, wsPing, make([]byte, 126))} {
+		if _, err := wsReadMessage(bufio.NewReader(bytes.NewReader(frame)), &bytes.Buffer{}); err == nil {
+			t.Errorf("frame % x read without an error", frame[:2])
+		}
+	}
+	// as is a message cut short
+	cut := unmasked(true, wsText, []byte("cut short"))
+	if _, err := wsReadMessage(bufio.NewReader(bytes.NewReader(cut[:6])), &bytes.Buffer{}); err == nil {
+		t.Error("a message cut short read without an error")
+	}
+}
+
+// unmaskedPong checks that frame is a masked pong, and returns it as a text
+// frame, so wsReadMessage returns its payload.
+func unmaskedPong(t *testing.T, frame []byte) []byte {
+	if len(frame) < 2 || frame[0] != 0x80|wsPong || frame[1]&0x80 == 0 {
+		t.Fatalf("sent % x, not a final, masked pong", frame)
+	}
+	pong := append([]byte{}, frame...)
+	pong[0] = 0x80 | wsText
+	return pong
+}
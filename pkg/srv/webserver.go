@@ -1,10 +1,18 @@
 package srv
 
 import (
+	pt "github.com/rafayopen/perftest/pkg/pt"
+	store "github.com/rafayopen/perftest/pkg/store"
+
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -63,3 +71,299 @@ func MemStatsReply(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("pongReply", r.RemoteAddr, active, alloc)
 }
+
+var (
+	summaryMu sync.Mutex
+	summaries []*pt.Summary // targets reported by StatsReply and MetricsReply
+)
+
+// AddSummary registers the Summary of a target to be reported by StatsReply
+// and MetricsReply.
+func AddSummary(s *pt.Summary) {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	summaries = append(summaries, s)
+}
+
+func reports() []pt.SummaryReport {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	r := make([]pt.SummaryReport, 0, len(summaries))
+	for _, s := range summaries {
+		r = append(r, s.Report())
+	}
+	return r
+}
+
+// StatsReply returns the current summary of each target as a JSON array.
+func StatsReply(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(reports()); err != nil {
+		log.Println("encoding stats:", err)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsReply returns the current summary of each target in the Prometheus
+// text exposition format.
+func MetricsReply(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	type gauge struct {
+		name, help, kind string
+		value            func(r *pt.SummaryReport, window string) float64
+	}
+	apdexOf := func(r *pt.SummaryReport, window string) *pt.ApdexScore {
+		if window == "recent" {
+			return &r.RecentApdex
+		}
+		return &r.Apdex
+	}
+	perTarget := []gauge{
+		{"perftest_samples_total", "Valid samples recorded.", "counter",
+			func(r *pt.SummaryReport, _ string) float64 { return float64(r.Count) }},
+		{"perftest_failures_total", "Requests that returned no result.", "counter",
+			func(r *pt.SummaryReport, _ string) float64 { return float64(r.Fails) }},
+		{"perftest_anomalies_total", "Samples with a time component over the anomaly threshold.", "counter",
+			func(r *pt.SummaryReport, _ string) float64 { return float64(r.Anomalies) }},
+		{"perftest_resp_time_avg_msec", "Average response time in milliseconds.", "gauge",
+			func(r *pt.SummaryReport, _ string) float64 { return r.Total }},
+	}
+	perWindow := []gauge{
+		{"perftest_apdex_score", "Apdex score, 0 to 1.", "gauge",
+			func(r *pt.SummaryReport, win string) float64 { return apdexOf(r, win).Score }},
+		{"perftest_apdex_threshold_msec", "Apdex threshold T in milliseconds.", "gauge",
+			func(r *pt.SummaryReport, win string) float64 { return pt.Msec(apdexOf(r, win).T) }},
+		{"perftest_apdex_satisfied", "Samples within T.", "gauge",
+			func(r *pt.SummaryReport, win string) float64 { return float64(apdexOf(r, win).Satisfied) }},
+		{"perftest_apdex_tolerating", "Samples within 4T.", "gauge",
+			func(r *pt.SummaryReport, win string) float64 { return float64(apdexOf(r, win).Tolerating) }},
+		{"perftest_apdex_frustrated", "Samples over 4T, or failed.", "gauge",
+			func(r *pt.SummaryReport, win string) float64 { return float64(apdexOf(r, win).Frustrated) }},
+	}
+
+	reps := reports()
+	labels := func(r *pt.SummaryReport) string {
+		l := fmt.Sprintf(`url="%s",location="%s"`,
+			labelEscaper.Replace(r.Url), labelEscaper.Replace(pt.LocationOrIp(&r.Location)))
+		if len(r.GroupBy) > 0 {
+			l += fmt.Sprintf(`,%s="%s"`, strings.ToLower(r.GroupBy), labelEscaper.Replace(r.Group))
+		}
+		return l
+	}
+	for _, g := range perTarget {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+		for i := range reps {
+			fmt.Fprintf(w, "%s{%s} %g\n", g.name, labels(&reps[i]), g.value(&reps[i], ""))
+		}
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", "perftest_ipv6_delta_msec",
+		"Average IPv6 minus IPv4 response time in milliseconds.", "perftest_ipv6_delta_msec", "gauge")
+	for i := range reps {
+		if reps[i].Pairs > 0 {
+			fmt.Fprintf(w, "%s{%s} %g\n", "perftest_ipv6_delta_msec", labels(&reps[i]), reps[i].V6Delta)
+		}
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", "perftest_throughput_bytes_per_sec",
+		"Average download or upload throughput in bytes per second.", "perftest_throughput_bytes_per_sec", "gauge")
+	for i := range reps {
+		if reps[i].Throughput > 0 {
+			fmt.Fprintf(w, "%s{%s,direction=\"download\"} %g\n", "perftest_throughput_bytes_per_sec", labels(&reps[i]), reps[i].Throughput)
+		}
+		if reps[i].UploadThroughput > 0 {
+			fmt.Fprintf(w, "%s{%s,direction=\"upload\"} %g\n", "perftest_throughput_bytes_per_sec", labels(&reps[i]), reps[i].UploadThroughput)
+		}
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", "perftest_server_timing_msec",
+		"Server-Timing metric durations in milliseconds.", "perftest_server_timing_msec", "histogram")
+	for i := range reps {
+		for _, m := range reps[i].ServerTiming {
+			l := fmt.Sprintf(`%s,metric="%s"`, labels(&reps[i]), labelEscaper.Replace(m.Name))
+			var cum int64
+			for b, le := range pt.HistogramBuckets {
+				cum += m.Counts[b]
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", "perftest_server_timing_msec", l, le, cum)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", "perftest_server_timing_msec", l, m.Count)
+			fmt.Fprintf(w, "%s_sum{%s} %g\n", "perftest_server_timing_msec", l, m.Sum)
+			fmt.Fprintf(w, "%s_count{%s} %d\n", "perftest_server_timing_msec", l, m.Count)
+		}
+	}
+	for _, g := range perWindow {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+		for i := range reps {
+			for _, win := range []string{"all", "recent"} {
+				fmt.Fprintf(w, "%s{%s,window=\"%s\"} %g\n", g.name, labels(&reps[i]), win, g.value(&reps[i], win))
+			}
+		}
+	}
+}
+
+var (
+	maintenance *pt.Maintenance // windows managed by MaintenanceReply
+	adminToken  string          // required to change them, or they cannot be
+)
+
+// SetMaintenance sets the maintenance windows managed by MaintenanceReply, and
+// the token a request must present to change them, as "Authorization: Bearer
+// token".  With no token they can only be listed.
+func SetMaintenance(m *pt.Maintenance, token string) {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	maintenance = m
+	adminToken = token
+}
+
+// authorized returns true if the request presents the admin token, or else
+// replies with an error.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if len(token) == 0 {
+		http.Error(w, "changes are disabled: set PERFTEST_ADMIN_TOKEN to enable them", http.StatusForbidden)
+		return false
+	}
+	// a bearer token is never sent by a browser on its own, so there is no CSRF
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// MaintenanceReply manages the maintenance windows: GET returns them as a JSON
+// array, POST adds the window in the JSON request body and returns it with its
+// ID, and DELETE with ?id=N removes one.  POST and DELETE need the admin token
+// (see SetMaintenance).
+func MaintenanceReply(w http.ResponseWriter, r *http.Request) {
+	summaryMu.Lock()
+	m, token := maintenance, adminToken
+	summaryMu.Unlock()
+	if m == nil {
+		http.Error(w, "maintenance windows are not enabled", http.StatusNotFound)
+		return
+	}
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !authorized(w, r, token) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	switch r.Method {
+	case http.MethodGet:
+		if err := enc.Encode(m.List(time.Now())); err != nil {
+			log.Println("encoding maintenance windows:", err)
+		}
+	case http.MethodPost:
+		var win pt.Window
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&win); err != nil {
+			http.Error(w, "parsing window: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		win, err := m.Add(win)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println("added maintenance window", win.ID, win.Name)
+		w.WriteHeader(http.StatusCreated)
+		enc.Encode(pt.WindowStatus{Window: win, Active: win.Active(time.Now())})
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "id is not a number", http.StatusBadRequest)
+			return
+		}
+		if !m.Remove(id) {
+			http.Error(w, fmt.Sprintf("no maintenance window %d", id), http.StatusNotFound)
+			return
+		}
+		log.Println("removed maintenance window", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+var results *store.Store // history reported by HistoryReply
+
+// Limits of a query of HistoryReply for each result, as there may be many.
+const (
+	MaxRawSpan    = 24 * time.Hour
+	MaxRawResults = 10000
+)
+
+// SetStore sets the store of results reported by HistoryReply.
+func SetStore(s *store.Store) {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	results = s
+}
+
+// HistoryReply returns the history of results from the store as a JSON array.
+// Query parameters select them: url (default all), from (default 1h, an hour
+// ago) and to (default now), as for store.ParseTime, and res, the resolution:
+// "raw" for each result, or "1m" or "1h" for rollups (default 1m for up to a
+// day, or else 1h).  Each result is returned from at most MaxRawSpan, and at
+// most MaxRawResults of them, the first.
+func HistoryReply(w http.ResponseWriter, r *http.Request) {
+	summaryMu.Lock()
+	s := results
+	summaryMu.Unlock()
+	if s == nil {
+		http.Error(w, "the store of results is not enabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now()
+	fromStr := q.Get("from")
+	if len(fromStr) == 0 {
+		fromStr = "1h"
+	}
+	from, err := store.ParseTime(fromStr, now)
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := store.ParseTime(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step, err := store.ParseResolution(q.Get("res"), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var history interface{}
+	if step == 0 {
+		if to.Sub(from) > MaxRawSpan {
+			http.Error(w, fmt.Sprintf("res=raw is limited to %g hours; use res=1m or 1h", MaxRawSpan.Hours()), http.StatusBadRequest)
+			return
+		}
+		history, err = s.Results(q.Get("url"), from, to, MaxRawResults)
+	} else {
+		history, err = s.Rollups(step, q.Get("url"), from, to)
+	}
+	if err != nil {
+		log.Println("querying history:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(history); err != nil {
+		log.Println("encoding history:", err)
+	}
+}
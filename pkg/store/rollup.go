@@ -0,0 +1,100 @@
+package store
+
+//  Rollups of results over a minute or an hour, for trends
+
+import (
+	pt "github.com/rafayopen/perftest/pkg/pt"
+
+	"fmt"
+	"time"
+)
+
+// Rollup summarizes the results for a URL that started in a minute or an hour.
+// Times are averages in milliseconds over all the results, failed or not,
+// except those of requests that were not sent, which have no times.
+type Rollup struct {
+	Url     string
+	Start   time.Time // of the minute or hour
+	Count   int64     // results
+	Fails   int64     // failed results (see PingTimes.Failed)
+	NotSent int64     `json:",omitempty"` // failed results of requests that could not be made, with no times
+	DnsLk   float64
+	TcpHs   float64
+	TlsHs   float64
+	Reply   float64
+	Close   float64
+	Total   float64
+	Min     float64      // least Total
+	Max     float64      // most Total
+	Latency pt.Histogram // of Total
+}
+
+// newRollup returns a rollup of one result.
+func newRollup(p *pt.PingTimes) Rollup {
+	if p.RespTime() == 0 { // not sent (see Store.Add)
+		return Rollup{Url: *p.DestUrl, Start: p.Start, Count: 1, Fails: 1, NotSent: 1}
+	}
+	r := Rollup{
+		Url:   *p.DestUrl,
+		Start: p.Start,
+		Count: 1,
+		DnsLk: pt.Msec(p.DnsLk),
+		TcpHs: pt.Msec(p.TcpHs),
+		TlsHs: pt.Msec(p.TlsHs),
+		Reply: pt.Msec(p.Reply),
+		Close: pt.Msec(p.Close),
+		Total: pt.Msec(p.RespTime()),
+	}
+	if p.Failed() {
+		r.Fails = 1
+	}
+	r.Min, r.Max = r.Total, r.Total
+	r.Latency.Add(p.RespTime())
+	return r
+}
+
+// timed returns the number of results with times.
+func (r *Rollup) timed() int64 {
+	return r.Count - r.NotSent
+}
+
+// merge adds the results of another rollup to r.
+func (r *Rollup) merge(o *Rollup) {
+	if o.Count == 0 {
+		return
+	}
+	r.Count += o.Count
+	r.Fails += o.Fails
+	r.NotSent += o.NotSent
+	if o.timed() == 0 {
+		return
+	}
+	if r.timed() == o.timed() || o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if r.timed() == o.timed() || o.Max > r.Max {
+		r.Max = o.Max
+	}
+	w := float64(o.timed()) / float64(r.timed()) // of o in the averages
+	avg := func(a *float64, b float64) { *a += (b - *a) * w }
+	avg(&r.DnsLk, o.DnsLk)
+	avg(&r.TcpHs, o.TcpHs)
+	avg(&r.TlsHs, o.TlsHs)
+	avg(&r.Reply, o.Reply)
+	avg(&r.Close, o.Close)
+	avg(&r.Total, o.Total)
+	r.Latency.Merge(&o.Latency)
+}
+
+// RollupHeader returns a column header for Rollup.Tsv.
+func RollupHeader() string {
+	return fmt.Sprintf("# %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		"timestamp", "Count", "Fails", "DNS", "TCP", "TLS", "First", "LastB", "Total", "Min", "Max", "proto://uri")
+}
+
+// Tsv returns a tab separated values string of the rollup, with the Unix time
+// of its start and times in milliseconds, like PingTimes.MsecTsv.
+func (r *Rollup) Tsv() string {
+	return fmt.Sprintf("%d\t%d\t%d\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%.03f\t%s",
+		r.Start.Unix(), r.Count, r.Fails, r.DnsLk, r.TcpHs, r.TlsHs, r.Reply, r.Close, r.Total, r.Min, r.Max, r.Url)
+}
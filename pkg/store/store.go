@@ -0,0 +1,495 @@
+// Package store keeps the results of perftest on disk, so their history
+// survives a restart and can be queried for trends.  Each result is appended
+// to a log of segment files, and rollups of the results of each URL per minute
+// and per hour are appended to logs of their own, which are kept longer.
+package store
+
+import (
+	pt "github.com/rafayopen/perftest/pkg/pt"
+
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options set how long the store keeps results and rollups.  Zero values mean
+// the default.
+type Options struct {
+	RawAge    time.Duration // keep each result this long (default 7 days)
+	MinuteAge time.Duration // keep rollups per minute this long (default 30 days)
+	HourAge   time.Duration // keep rollups per hour this long (default 400 days)
+	MaxBytes  int64         // most bytes to keep, removing the oldest results first, then rollups (default no limit)
+}
+
+// rollupDelay is how late a result may be added, after the end of the minute
+// or hour it started in, and still be in the first rollup written for it.
+// Later results are in another rollup of the same interval, and the two are
+// merged by Rollups.
+const rollupDelay = time.Minute
+
+// segmentExt is the file name extension of a segment: JSON, one record a line.
+const segmentExt = ".jsonl"
+
+// tier is a log of segment files, of results or of rollups per step.
+type tier struct {
+	name   string                          // of the directory of the segments
+	step   time.Duration                   // of a rollup, or zero for results
+	layout string                          // of the name of a segment, from its start in UTC
+	next   func(start time.Time) time.Time // start of the segment after
+	age    time.Duration                   // to keep a segment, from its end
+
+	file      *os.File // segment being appended to, if any
+	fileStart time.Time
+	open      map[rollupKey]*Rollup // rollups not written yet
+}
+
+type rollupKey struct {
+	url   string
+	start int64 // Unix time
+}
+
+// segment returns the start of the segment of the tier for time t.
+func (tr *tier) segment(t time.Time) time.Time {
+	start, _ := time.Parse(tr.layout, t.UTC().Format(tr.layout)) // truncates to the layout
+	return start
+}
+
+// Store is an on-disk store of results, safe for concurrent use.
+type Store struct {
+	dir      string
+	opts     Options
+	readOnly bool
+	tiers    []*tier // results, then rollups per minute and per hour
+
+	mu      sync.Mutex
+	written int64 // bytes appended since retention was last enforced
+}
+
+func newStore(dir string, opts Options) *Store {
+	if opts.RawAge <= 0 {
+		opts.RawAge = 7 * 24 * time.Hour
+	}
+	if opts.MinuteAge <= 0 {
+		opts.MinuteAge = 30 * 24 * time.Hour
+	}
+	if opts.HourAge <= 0 {
+		opts.HourAge = 400 * 24 * time.Hour
+	}
+	return &Store{
+		dir:  dir,
+		opts: opts,
+		tiers: []*tier{
+			{name: "raw", layout: "2006010215", age: opts.RawAge,
+				next: func(t time.Time) time.Time { return t.Add(time.Hour) }},
+			{name: "1m", step: time.Minute, layout: "20060102", age: opts.MinuteAge,
+				next: func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+			{name: "1h", step: time.Hour, layout: "200601", age: opts.HourAge,
+				next: func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		},
+	}
+}
+
+// Open opens the store in the directory, creating it if need be, removes what
+// it should no longer keep, and rebuilds the rollups that were not written when
+// it was last used.  Close it when done adding results.
+func Open(dir string, opts Options) (*Store, error) {
+	s := newStore(dir, opts)
+	for _, tr := range s.tiers {
+		if err := os.MkdirAll(filepath.Join(dir, tr.name), 0755); err != nil {
+			return nil, err
+		}
+		tr.open = make(map[rollupKey]*Rollup)
+	}
+	s.enforce(time.Now())
+	if err := s.rebuild(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rebuild recreates the rollups that were never written, as when the process
+// adding to the store stopped without closing it, from the results kept since
+// the last rollup written to each tier.  They are written as the rollups of new
+// results are, when their interval is over, or on Close.
+func (s *Store) rebuild() error {
+	for _, tr := range s.tiers[1:] {
+		segs, err := s.segments(tr)
+		if err != nil {
+			return err
+		}
+		var from time.Time // of the first rollup not written
+		if len(segs) > 0 {
+			last := segs[len(segs)-1]
+			err := s.scan(tr, last.start, last.end, func(line []byte) bool {
+				var r Rollup
+				if json.Unmarshal(line, &r) == nil && !r.Start.Before(from) {
+					from = r.Start.Add(tr.step)
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+		results, err := s.Results("", from, time.Now(), 0)
+		if err != nil {
+			return err
+		}
+		for _, p := range results {
+			one := newRollup(p)
+			tr.add(&one)
+		}
+	}
+	return nil
+}
+
+// add merges a rollup of one result into the open rollup of its interval.
+func (tr *tier) add(one *Rollup) {
+	key := rollupKey{one.Url, one.Start.Truncate(tr.step).Unix()}
+	r := tr.open[key]
+	if r == nil {
+		r = &Rollup{Url: key.url, Start: time.Unix(key.start, 0).UTC()}
+		tr.open[key] = r
+	}
+	r.merge(one)
+}
+
+// OpenReadOnly opens the store in the directory for queries only, as while
+// another process adds to it.  It sees rollups only once that process has
+// written them, after the end of their minute or hour.
+func OpenReadOnly(dir string) (*Store, error) {
+	if _, err := os.Stat(filepath.Join(dir, "raw")); err != nil {
+		return nil, fmt.Errorf("%s is not a store: %v", dir, err)
+	}
+	s := newStore(dir, Options{})
+	s.readOnly = true
+	return s, nil
+}
+
+// Add appends a result to the store and to the rollups of its URL.  A result
+// with no times, like one made up for a request that could not be made, is a
+// failure that was not sent (see Rollup.NotSent).
+func (s *Store) Add(p *pt.PingTimes) error {
+	if p == nil || p.DestUrl == nil {
+		return nil
+	}
+	if s.readOnly {
+		return fmt.Errorf("store %s is read-only", s.dir)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.RespTime()
+	if err := s.append(s.tiers[0], p.Start, p); err != nil {
+		return err
+	}
+	one := newRollup(p)
+	for _, tr := range s.tiers[1:] {
+		tr.add(&one)
+
+		// write the rollups that no more results are expected for
+		for k, r := range tr.open {
+			if p.Start.Sub(time.Unix(k.start, 0)) >= tr.step+rollupDelay {
+				if err := s.append(tr, r.Start, r); err != nil {
+					return err
+				}
+				delete(tr.open, k)
+			}
+		}
+	}
+	if s.opts.MaxBytes > 0 && s.written > s.opts.MaxBytes/10 {
+		s.enforce(time.Now())
+	}
+	return nil
+}
+
+// append writes a record to the segment of the tier for time t.
+func (s *Store) append(tr *tier, t time.Time, record interface{}) error {
+	start := tr.segment(t)
+	if tr.file == nil || !start.Equal(tr.fileStart) {
+		rotated := tr.file != nil && start.After(tr.fileStart)
+		if tr.file != nil {
+			tr.file.Close()
+		}
+		path := filepath.Join(s.dir, tr.name, start.Format(tr.layout)+segmentExt)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			tr.file = nil
+			return err
+		}
+		tr.file, tr.fileStart = f, start
+		if rotated && tr.step == 0 {
+			s.enforce(time.Now())
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	n, err := tr.file.Write(append(line, '\n')) // one write, so readers never see half a line
+	s.written += int64(n)
+	return err
+}
+
+// Close writes the rollups not written yet and closes the store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, tr := range s.tiers {
+		for k, r := range tr.open {
+			if err := s.append(tr, r.Start, r); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			delete(tr.open, k)
+		}
+		if tr.file != nil {
+			if err := tr.file.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			tr.file = nil
+		}
+	}
+	return firstErr
+}
+
+// segmentFile is a segment of a tier on disk.
+type segmentFile struct {
+	tier       *tier
+	path       string
+	start, end time.Time
+	size       int64
+}
+
+// segments returns the segments of the tier, oldest first.
+func (s *Store) segments(tr *tier) ([]segmentFile, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, tr.name))
+	if err != nil {
+		return nil, err
+	}
+	var segs []segmentFile
+	for _, info := range infos { // sorted by name, so by start
+		name := info.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		start, err := time.Parse(tr.layout, strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segmentFile{tier: tr, path: filepath.Join(s.dir, tr.name, name),
+			start: start, end: tr.next(start), size: info.Size()})
+	}
+	return segs, nil
+}
+
+// enforce removes the segments older than their tier keeps, and then, while
+// the store is over MaxBytes, the oldest results and then the oldest rollups.
+// The segments being appended to are kept.
+func (s *Store) enforce(now time.Time) {
+	s.written = 0
+	var kept []segmentFile
+	var total int64
+	for _, tr := range s.tiers {
+		segs, err := s.segments(tr)
+		if err != nil {
+			log.Println("store:", err)
+			continue
+		}
+		for _, seg := range segs {
+			current := tr.file != nil && seg.start.Equal(tr.fileStart)
+			if !current && seg.end.Before(now.Add(-tr.age)) {
+				s.remove(seg)
+				continue
+			}
+			if !current {
+				kept = append(kept, seg)
+			}
+			total += seg.size
+		}
+	}
+	for _, seg := range kept { // by tier, oldest first
+		if s.opts.MaxBytes <= 0 || total <= s.opts.MaxBytes {
+			break
+		}
+		s.remove(seg)
+		total -= seg.size
+	}
+}
+
+func (s *Store) remove(seg segmentFile) {
+	if err := os.Remove(seg.path); err != nil {
+		log.Println("store:", err)
+	}
+}
+
+// scan calls fn with each line of the segments of the tier that may have
+// records from from until to, until fn returns false.
+func (s *Store) scan(tr *tier, from, to time.Time, fn func(line []byte) bool) error {
+	segs, err := s.segments(tr)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		if !seg.end.After(from) || !seg.start.Before(to) {
+			continue
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		more := true
+		for more && scanner.Scan() {
+			more = fn(scanner.Bytes())
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", seg.path, err)
+		}
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// Results returns the results that started from from until to, for the URL or
+// for all URLs if it is empty, in order: with max, at most that many, the first
+// ones stored.
+func (s *Store) Results(url string, from, to time.Time, max int) ([]*pt.PingTimes, error) {
+	results := make([]*pt.PingTimes, 0)
+	err := s.scan(s.tiers[0], from, to, func(line []byte) bool {
+		p := new(pt.PingTimes)
+		if json.Unmarshal(line, p) != nil { // as a line cut short by a crash
+			return true
+		}
+		if p.Start.Before(from) || !p.Start.Before(to) || p.DestUrl == nil ||
+			(len(url) > 0 && *p.DestUrl != url) {
+			return true
+		}
+		results = append(results, p)
+		return max <= 0 || len(results) < max
+	})
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Start.Before(results[j].Start) })
+	return results, err
+}
+
+// Rollups returns the rollups per step, time.Minute or time.Hour, of the
+// intervals from from until to, for the URL or for all URLs if it is empty,
+// in order of time and then URL.  They include the results added since the
+// last rollups were written.
+func (s *Store) Rollups(step time.Duration, url string, from, to time.Time) ([]Rollup, error) {
+	var tr *tier
+	for _, t := range s.tiers[1:] {
+		if t.step == step {
+			tr = t
+		}
+	}
+	if tr == nil {
+		return nil, fmt.Errorf("there are no rollups per %s, only per minute or hour", step)
+	}
+	from = from.Truncate(step)
+
+	byKey := make(map[rollupKey]*Rollup)
+	add := func(r *Rollup) {
+		if r.Start.Before(from) || !r.Start.Before(to) || (len(url) > 0 && r.Url != url) {
+			return
+		}
+		key := rollupKey{r.Url, r.Start.Unix()}
+		if have := byKey[key]; have != nil {
+			have.merge(r)
+		} else {
+			c := Rollup{Url: r.Url, Start: r.Start}
+			c.merge(r)
+			byKey[key] = &c
+		}
+	}
+	err := s.scan(tr, from, to, func(line []byte) bool {
+		var r Rollup
+		if json.Unmarshal(line, &r) == nil {
+			add(&r)
+		}
+		return true
+	})
+	s.mu.Lock()
+	for _, r := range tr.open {
+		add(r)
+	}
+	s.mu.Unlock()
+
+	rollups := make([]Rollup, 0, len(byKey))
+	for _, r := range byKey {
+		rollups = append(rollups, *r)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if !rollups[i].Start.Equal(rollups[j].Start) {
+			return rollups[i].Start.Before(rollups[j].Start)
+		}
+		return rollups[i].Url < rollups[j].Url
+	})
+	return rollups, err
+}
+
+// ParseAge parses a duration like "90m" or "36h", or a number of days like
+// "7d".
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseTime parses a time for a query: "now", an RFC 3339 time like
+// "2024-06-01T02:00:00Z", Unix seconds, or an age before now like "6h" or "7d".
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" || len(s) == 0 {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	age, err := ParseAge(s)
+	if err != nil {
+		return now, fmt.Errorf("%q is not a time, Unix seconds or an age like 6h", s)
+	}
+	return now.Add(-age), nil
+}
+
+// ParseResolution parses the resolution of a query: "raw" for each result,
+// returned as zero, or "1m" or "1h" for rollups per minute or hour.  Empty is
+// 1m for up to a day from from to to, or else 1h.
+func ParseResolution(res string, from, to time.Time) (time.Duration, error) {
+	switch res {
+	case "raw":
+		return 0, nil
+	case "1m":
+		return time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	case "":
+		if to.Sub(from) > 24*time.Hour {
+			return time.Hour, nil
+		}
+		return time.Minute, nil
+	}
+	return 0, fmt.Errorf("resolution %q is not raw, 1m or 1h", res)
+}
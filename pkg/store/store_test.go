@@ -0,0 +1,246 @@
+package store
+
+import (
+	pt "github.com/rafayopen/perftest/pkg/pt"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// result returns a result from url at start, with a response time of msec,
+// and the HTTP status code.
+func result(url string, start time.Time, msec int, code int) *pt.PingTimes {
+	return &pt.PingTimes{
+		Start:    start,
+		TcpHs:    time.Millisecond,
+		Reply:    time.Duration(msec-1) * time.Millisecond,
+		DestUrl:  &url,
+		RespCode: code,
+	}
+}
+
+func TestAddRollups(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := "https://a.example/", "https://b.example/"
+	// minute m of an hour that is kept, and over
+	hour := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	m := func(n int, sec int) time.Time {
+		return hour.Add(time.Duration(n)*time.Minute + time.Duration(sec)*time.Second)
+	}
+	for _, p := range []*pt.PingTimes{
+		result(a, m(0, 10), 10, 200),
+		result(a, m(0, 40), 30, 200),
+		result(b, m(0, 20), 100, 500),
+		{Start: m(0, 50), DestUrl: &a, RespCode: -1}, // not sent: no times
+		result(a, m(1, 5), 20, 200),
+	} {
+		if err := s.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Results(a, hour, hour.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || !results[0].Start.Equal(m(0, 10)) || !results[3].Start.Equal(m(1, 5)) {
+		t.Errorf("got %d results of %s, want 4 in order", len(results), a)
+	}
+	if results, _ := s.Results("", hour, hour.Add(time.Hour), 2); len(results) != 2 {
+		t.Errorf("got %d results of at most 2", len(results))
+	}
+
+	check := func(r Rollup, start time.Time, count, fails, notSent int64, total, min, max float64) {
+		t.Helper()
+		if !r.Start.Equal(start) || r.Count != count || r.Fails != fails || r.NotSent != notSent ||
+			r.Total != total || r.Min != min || r.Max != max || r.Latency.Count != count-notSent {
+			t.Errorf("rollup %+v, want Start %s Count %d Fails %d NotSent %d Total %g Min %g Max %g",
+				r, start.Format(time.RFC3339), count, fails, notSent, total, min, max)
+		}
+	}
+	rollups, err := s.Rollups(time.Minute, a, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 2 {
+		t.Fatalf("got %d rollups per minute of %s, want 2: %+v", len(rollups), a, rollups)
+	}
+	check(rollups[0], m(0, 0), 3, 1, 1, 20, 10, 30) // the one not sent is not in the times
+	check(rollups[1], m(1, 0), 1, 0, 0, 20, 20, 20)
+
+	rollups, err = s.Rollups(time.Hour, "", hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 2 || rollups[0].Url != a || rollups[1].Url != b {
+		t.Fatalf("got %d rollups per hour, want one of %s and one of %s: %+v", len(rollups), a, b, rollups)
+	}
+	check(rollups[0], hour, 4, 1, 1, 20, 10, 30)
+	check(rollups[1], hour, 1, 1, 0, 100, 100, 100)
+
+	// a result later than the rollup delay writes the rollups of minute 0
+	if err := s.Add(result(a, m(3, 0), 40, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if written, _ := ioutil.ReadFile(filepath.Join(dir, "1m", hour.UTC().Format("20060102")+segmentExt)); len(written) == 0 {
+		t.Error("no rollups per minute written")
+	}
+	// and a late result of minute 0 is in another rollup, merged
+	if err := s.Add(result(a, m(0, 59), 50, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollups, err = r.Rollups(time.Minute, a, hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 3 {
+		t.Fatalf("got %d rollups per minute of %s after Close, want 3", len(rollups), a)
+	}
+	check(rollups[0], m(0, 0), 4, 1, 1, 30, 10, 50)
+	if err := r.Add(result(a, m(4, 0), 10, 200)); err == nil {
+		t.Error("added to a store opened read-only")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := "https://a.example/"
+	hour := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i := 0; i < 10; i++ {
+		if err := s.Add(result(a, hour.Add(time.Duration(i)*time.Minute), 10, 200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// stopped without Close: the last rollups per minute and all per hour are not written
+	for _, tr := range s.tiers {
+		tr.file.Close()
+	}
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []time.Duration{time.Minute, time.Hour} {
+		rollups, err := r.Rollups(step, a, hour, hour.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		for _, r := range rollups {
+			count += r.Count
+		}
+		if count != 10 {
+			t.Errorf("rollups per %s of %d results after the rebuild, want 10", step, count)
+		}
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{RawAge: 3 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// a segment of results per hour, from 5 hours ago
+	var paths []string
+	for h := 5; h > 0; h-- {
+		start := now.Add(-time.Duration(h) * time.Hour)
+		for i := 0; i < 5; i++ {
+			if err := s.Add(result("https://a.example/", start.Add(time.Duration(i)*time.Second), 10, 200)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths = append(paths, filepath.Join(dir, "raw", start.UTC().Format("2006010215")+segmentExt))
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	check := func(what string, want ...bool) {
+		t.Helper()
+		for i := range want {
+			if exists(paths[i]) != want[i] {
+				t.Errorf("%s: segment of %d hours ago kept %v, want %v", what, 5-i, exists(paths[i]), want[i])
+			}
+		}
+	}
+	size := func() (total int64) {
+		filepath.Walk(dir, func(_ string, info os.FileInfo, _ error) error {
+			if info != nil && !info.IsDir() {
+				total += info.Size()
+			}
+			return nil
+		})
+		return total
+	}
+
+	// those that end more than RawAge ago
+	s.enforce(now)
+	check("RawAge", false, false, true, true, true)
+	// then the oldest, while over MaxBytes
+	oldest, err := os.Stat(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.opts.MaxBytes = size() - oldest.Size()
+	s.enforce(now)
+	check("MaxBytes", false, false, false, true, true)
+	// but not the one being appended to
+	s.opts.MaxBytes = 1
+	s.enforce(now)
+	check("MaxBytes 1", false, false, false, false, true)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Duration{"90m": 90 * time.Minute, "7d": 7 * 24 * time.Hour, "1.5d": 36 * time.Hour} {
+		if age, err := ParseAge(s); err != nil || age != want {
+			t.Errorf("ParseAge(%q) = %s, %v, want %s", s, age, err, want)
+		}
+	}
+	for s, want := range map[string]time.Time{
+		"":                     now,
+		"now":                  now,
+		"6h":                   now.Add(-6 * time.Hour),
+		"2d":                   now.Add(-48 * time.Hour),
+		"1717400000":           time.Unix(1717400000, 0),
+		"2024-06-01T02:00:00Z": time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC),
+	} {
+		if tm, err := ParseTime(s, now); err != nil || !tm.Equal(want) {
+			t.Errorf("ParseTime(%q) = %s, %v, want %s", s, tm, err, want)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error(`ParseTime("yesterday") succeeded`)
+	}
+}